@@ -1,34 +1,44 @@
 package common
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
 	"time"
-)
 
-// SetupArminoEnv validates ARMINO_PATH and activates Python venv if available.
-func SetupArminoEnv(toolPrefix string) (string, error) {
-	arminoPath := os.Getenv("ARMINO_PATH")
-	if arminoPath == "" {
-		return "", fmt.Errorf("ARMINO_PATH not set\nAdd to .bazelrc.user:\n  build --//bazel:armino_path=/path/to/bk_avdk_smp")
-	}
+	"embed-zig/bazel/common/toolchain"
+)
 
-	if info, err := os.Stat(arminoPath); err != nil || !info.IsDir() {
-		return "", fmt.Errorf("ARMINO_PATH=%s does not exist", arminoPath)
+// SetupArminoEnv resolves the Armino SDK via toolchain.Resolve, activates its
+// Python venv in the current process's PATH if available, and returns its
+// root. arminoPathFlag is the --//bazel:armino_path value, if the caller has
+// one ("" falls through to .embed-zig.toml / ARMINO_PATH / ~/armino).
+func SetupArminoEnv(arminoPathFlag, toolPrefix string) (string, error) {
+	tc, err := toolchain.Resolve(toolchain.ToolchainSpec{
+		Kind:          toolchain.Armino,
+		BazelFlag:     arminoPathFlag,
+		WorkspaceRoot: os.Getenv("BUILD_WORKSPACE_DIRECTORY"),
+		ToolPrefix:    toolPrefix,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	// Activate Python venv if available
-	venvActivate := filepath.Join(arminoPath, "venv", "bin", "activate")
-	if _, err := os.Stat(venvActivate); err == nil {
-		venvBin := filepath.Join(arminoPath, "venv", "bin")
-		path := os.Getenv("PATH")
-		os.Setenv("PATH", venvBin+string(os.PathListSeparator)+path)
+	if len(tc.BinPaths()) > 0 {
+		os.Setenv("PATH", strings.Join(tc.BinPaths(), string(os.PathListSeparator))+string(os.PathListSeparator)+os.Getenv("PATH"))
 	}
 
-	fmt.Printf("%s Armino SDK: %s\n", toolPrefix, arminoPath)
-	return arminoPath, nil
+	return tc.Root, nil
 }
 
 // FindBkLoader locates the bk_loader binary.
@@ -71,19 +81,110 @@ func KillPortProcess(port string, toolPrefix string) {
 
 // RunCommand executes a command with stdout/stderr redirected.
 func RunCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	_, err := RunCommandCtx(context.Background(), RunOptions{Name: name, Args: args})
+	return err
 }
 
 // RunCommandInDir executes a command in a specific directory.
 func RunCommandInDir(dir string, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	_, err := RunCommandCtx(context.Background(), RunOptions{Name: name, Args: args, Dir: dir})
+	return err
+}
+
+// RunOptions configures RunCommandCtx.
+type RunOptions struct {
+	Name  string
+	Args  []string
+	Dir   string
+	Env   []string // nil inherits the current process environment
+	Stdin io.Reader
+
+	// Timeout bounds the whole invocation; zero means no timeout beyond ctx.
+	Timeout time.Duration
+
+	// TeeStdout/TeeStderr additionally receive a copy of the command's
+	// output, on top of the os.Stdout/os.Stderr passthrough.
+	TeeStdout io.Writer
+	TeeStderr io.Writer
+
+	// NoPassthrough skips the os.Stdout/os.Stderr copy, leaving TeeStdout/
+	// TeeStderr (if set) as the only live destination. Set this when the
+	// caller buffers a job's output itself and flushes it as one block later
+	// — e.g. a WorkQueue job, where direct passthrough would interleave
+	// concurrent jobs' output on the terminal.
+	NoPassthrough bool
+
+	// KillGracePeriod, if set, sends SIGINT on cancellation and escalates to
+	// SIGKILL only after this long — important for ESP-IDF builds that leave
+	// dangling ninja processes behind a bare kill.
+	KillGracePeriod time.Duration
+}
+
+// RunResult captures the outcome of a RunCommandCtx invocation.
+type RunResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+}
+
+// RunCommandCtx runs opts.Name/opts.Args under ctx, honoring cancellation,
+// opts.Timeout, and opts.KillGracePeriod, and logs a structured one-liner
+// (cmd, dir, duration_ms, exit) for post-mortem. Output is streamed to
+// os.Stdout/os.Stderr unless opts.NoPassthrough is set, tee'd to
+// opts.TeeStdout/TeeStderr if set, and also captured into the returned
+// RunResult.
+func RunCommandCtx(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, opts.Name, opts.Args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.Stdin = opts.Stdin
+
+	var outBuf, errBuf bytes.Buffer
+	stdoutWriters := []io.Writer{&outBuf}
+	stderrWriters := []io.Writer{&errBuf}
+	if !opts.NoPassthrough {
+		stdoutWriters = append(stdoutWriters, os.Stdout)
+		stderrWriters = append(stderrWriters, os.Stderr)
+	}
+	if opts.TeeStdout != nil {
+		stdoutWriters = append(stdoutWriters, opts.TeeStdout)
+	}
+	if opts.TeeStderr != nil {
+		stderrWriters = append(stderrWriters, opts.TeeStderr)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+
+	if opts.KillGracePeriod > 0 {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+		cmd.WaitDelay = opts.KillGracePeriod
+	}
+
+	start := time.Now()
+	runErr := cmd.Run()
+	duration := time.Since(start)
+
+	exitCode := 0
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	} else if runErr != nil {
+		exitCode = -1
+	}
+
+	log.Printf("cmd=%q dir=%q duration_ms=%d exit=%d",
+		strings.TrimSpace(opts.Name+" "+strings.Join(opts.Args, " ")), opts.Dir, duration.Milliseconds(), exitCode)
+
+	result := &RunResult{ExitCode: exitCode, Stdout: outBuf.Bytes(), Stderr: errBuf.Bytes(), Duration: duration}
+	return result, runErr
 }
 
 // CopyFile copies a file from src to dst.
@@ -112,110 +213,98 @@ func FileExists(path string) bool {
 	return err == nil
 }
 
-// FindFileRecursive finds the first file matching name under root directory.
-func FindFileRecursive(root, name string) string {
-	var result string
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() && info.Name() == name && result == "" {
-			result = path
-		}
-		return nil
-	})
-	return result
-}
-
-// FindFileWithPath finds a file under root where the path contains the given substring.
-func FindFileWithPath(root, name, pathContains string) string {
-	var result string
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() && info.Name() == name {
-			if pathContains == "" || containsStr(path, pathContains) {
-				if result == "" {
-					result = path
-				}
-			}
-		}
-		return nil
-	})
-	return result
-}
+// Sha256File returns the lowercase hex SHA-256 of path's contents, for
+// verifying a flashed image's read-back against its source binary.
+func Sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
 
-// FindFileWithPathExclude finds a file matching name+pathContains but NOT pathExcludes.
-func FindFileWithPathExclude(root, name, pathContains, pathExcludes string) string {
-	var result string
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() && info.Name() == name {
-			if (pathContains == "" || containsStr(path, pathContains)) &&
-				(pathExcludes == "" || !containsStr(path, pathExcludes)) {
-				if result == "" {
-					result = path
-				}
-			}
-		}
-		return nil
-	})
-	return result
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func containsStr(s, substr string) bool {
-	return len(s) >= len(substr) && searchStr(s, substr)
+// ReplaceInFile performs a literal string replacement in a file.
+func ReplaceInFile(path, old, new string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte(old)) {
+		return nil // nothing to replace
+	}
+	updated := bytes.ReplaceAll(data, []byte(old), []byte(new))
+	return writeFileAtomic(path, updated)
 }
 
-func searchStr(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
+// ReplaceInFileRegex replaces every match of pattern in path with repl, following
+// the same submatch-expansion rules as (*regexp.Regexp).ReplaceAll. Use this for
+// board-bringup edits (chip target, flash size, ...) where the replacement depends
+// on what matched, not just a literal string.
+func ReplaceInFileRegex(path string, pattern *regexp.Regexp, repl string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
-	return false
+	updated := pattern.ReplaceAll(data, []byte(repl))
+	if bytes.Equal(data, updated) {
+		return nil
+	}
+	return writeFileAtomic(path, updated)
 }
 
-// ReplaceInFile performs a string replacement in a file.
-func ReplaceInFile(path, old, new string) error {
+// TemplateFile renders path as a Go text/template using vars and writes the
+// result back in place, e.g. turning `CONFIG_IDF_TARGET="{{.Chip}}"` in
+// sdkconfig.defaults into the real chip name.
+func TemplateFile(path string, vars map[string]string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	content := string(data)
-	if !containsStr(content, old) {
-		return nil // nothing to replace
+
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("parse template %s: %w", path, err)
 	}
-	updated := replaceAll(content, old, new)
-	return os.WriteFile(path, []byte(updated), 0644)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return fmt.Errorf("render template %s: %w", path, err)
+	}
+
+	return writeFileAtomic(path, buf.Bytes())
 }
 
-func replaceAll(s, old, new string) string {
-	if old == new || old == "" {
-		return s
+// writeFileAtomic writes data to path via a temp file + rename so a Ctrl-C
+// mid-write can never leave a half-written sdkconfig/Kconfig/CMakeLists behind.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	mode := os.FileMode(0644)
+	if err == nil {
+		mode = info.Mode()
 	}
-	var result []byte
-	for {
-		i := indexOf(s, old)
-		if i < 0 {
-			result = append(result, s...)
-			break
-		}
-		result = append(result, s[:i]...)
-		result = append(result, new...)
-		s = s[i+len(old):]
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
 	}
-	return string(result)
-}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once renamed away
 
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
 	}
-	return -1
+	return os.Rename(tmpPath, path)
 }
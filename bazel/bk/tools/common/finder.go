@@ -0,0 +1,207 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultSkipDirs are pruned from every Finder walk; Armino/ESP-IDF trees
+// carry tens of thousands of files under these, and nothing useful lives in
+// them for a board-bringup search.
+var defaultSkipDirs = map[string]bool{
+	".git":  true,
+	"build": true,
+}
+
+func isSkippedDir(name string) bool {
+	return defaultSkipDirs[name] || strings.HasPrefix(name, "bazel-")
+}
+
+// Finder replaces FindFileRecursive/FindFileWithPath/FindFileWithPathExclude
+// with a single, depth-bounded, cancellable, optionally-parallel file search.
+type Finder struct {
+	root         string
+	namePattern  string
+	pathContains string
+	pathExcludes string
+	maxDepth     int // 0 = unlimited
+	parallel     int
+}
+
+// NewFinder starts a search rooted at root.
+func NewFinder(root string) *Finder {
+	return &Finder{root: root, parallel: 1}
+}
+
+// Name filters to files whose base name matches the glob pattern (see
+// path/filepath.Match for syntax).
+func (f *Finder) Name(glob string) *Finder {
+	f.namePattern = glob
+	return f
+}
+
+// PathContains filters to files whose full path contains sub.
+func (f *Finder) PathContains(sub string) *Finder {
+	f.pathContains = sub
+	return f
+}
+
+// PathExcludes filters out files whose full path contains sub.
+func (f *Finder) PathExcludes(sub string) *Finder {
+	f.pathExcludes = sub
+	return f
+}
+
+// MaxDepth bounds how many directories deep under root the walk descends (0
+// means unlimited).
+func (f *Finder) MaxDepth(n int) *Finder {
+	f.maxDepth = n
+	return f
+}
+
+// Parallel fans the walk out across the root's immediate subdirectories using
+// up to k worker goroutines (k <= 1 walks sequentially).
+func (f *Finder) Parallel(k int) *Finder {
+	f.parallel = k
+	return f
+}
+
+// errFirstFound short-circuits First's walk once a match is seen.
+var errFirstFound = errors.New("finder: first match found")
+
+// First returns the first matching path, or "" if none is found before ctx is
+// done or the walk completes.
+func (f *Finder) First(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var result string
+
+	err := f.walk(ctx, func(path string) error {
+		mu.Lock()
+		if result == "" {
+			result = path
+		}
+		mu.Unlock()
+		cancel()
+		return errFirstFound
+	})
+	if err != nil && !errors.Is(err, errFirstFound) && !errors.Is(err, context.Canceled) {
+		return "", err
+	}
+	return result, nil
+}
+
+// All returns every matching path found before ctx is done or the walk
+// completes.
+func (f *Finder) All(ctx context.Context) ([]string, error) {
+	var mu sync.Mutex
+	var results []string
+
+	err := f.walk(ctx, func(path string) error {
+		mu.Lock()
+		results = append(results, path)
+		mu.Unlock()
+		return nil
+	})
+	return results, err
+}
+
+// walk drives the directory traversal, fanning out across root's immediate
+// children when f.parallel > 1.
+func (f *Finder) walk(ctx context.Context, visit func(path string) error) error {
+	if f.parallel <= 1 {
+		return f.walkDir(ctx, f.root, visit)
+	}
+
+	entries, err := os.ReadDir(f.root)
+	if err != nil {
+		return f.walkDir(ctx, f.root, visit) // root itself may be a file-ish edge case; fall back
+	}
+
+	sem := make(chan struct{}, f.parallel)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(entries))
+
+	for _, e := range entries {
+		if e.IsDir() && isSkippedDir(e.Name()) {
+			continue
+		}
+		child := filepath.Join(f.root, e.Name())
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := f.walkDir(ctx, path, visit); err != nil {
+				errs <- err
+			}
+		}(child)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil && !errors.Is(err, errFirstFound) && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir runs a single, cancellable filepath.WalkDir rooted at root.
+func (f *Finder) walkDir(ctx context.Context, root string, visit func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable subtree: skip, don't abort the whole search
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() {
+			if path != root && isSkippedDir(d.Name()) {
+				return fs.SkipDir
+			}
+			if f.maxDepth > 0 && depth(f.root, path) >= f.maxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if f.namePattern != "" {
+			if ok, _ := filepath.Match(f.namePattern, d.Name()); !ok {
+				return nil
+			}
+		}
+		if f.pathContains != "" && !strings.Contains(path, f.pathContains) {
+			return nil
+		}
+		if f.pathExcludes != "" && strings.Contains(path, f.pathExcludes) {
+			return nil
+		}
+
+		return visit(path)
+	})
+}
+
+// depth counts path separators between root and path.
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, r := range rel {
+		if r == filepath.Separator {
+			n++
+		}
+	}
+	return n
+}
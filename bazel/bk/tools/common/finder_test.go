@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustMkTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFinderFirst(t *testing.T) {
+	root := mustMkTree(t, map[string]string{
+		"bk7258_ap/app.bin": "ap",
+		"bk7258_cp/app.bin": "cp",
+	})
+
+	got, err := NewFinder(root).Name("app.bin").PathContains("bk7258_ap").First(context.Background())
+	if err != nil {
+		t.Fatalf("First: %v", err)
+	}
+	want := filepath.Join(root, "bk7258_ap/app.bin")
+	if got != want {
+		t.Errorf("First() = %q, want %q", got, want)
+	}
+}
+
+func TestFinderPathExcludes(t *testing.T) {
+	root := mustMkTree(t, map[string]string{
+		"bk7258_ap/app.bin": "ap",
+		"bk7258/app.bin":    "cp",
+	})
+
+	got, err := NewFinder(root).Name("app.bin").PathContains("bk7258").PathExcludes("bk7258_ap").First(context.Background())
+	if err != nil {
+		t.Fatalf("First: %v", err)
+	}
+	want := filepath.Join(root, "bk7258/app.bin")
+	if got != want {
+		t.Errorf("First() = %q, want %q", got, want)
+	}
+}
+
+func TestFinderSkipsGitAndBazelDirs(t *testing.T) {
+	root := mustMkTree(t, map[string]string{
+		".git/app.bin":      "skip",
+		"bazel-out/app.bin": "skip",
+		"src/app.bin":       "keep",
+	})
+
+	got, err := NewFinder(root).Name("app.bin").All(context.Background())
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(got) != 1 || got[0] != filepath.Join(root, "src/app.bin") {
+		t.Errorf("All() = %v, want just src/app.bin", got)
+	}
+}
+
+func TestFinderMaxDepth(t *testing.T) {
+	root := mustMkTree(t, map[string]string{
+		"a.bin":         "depth0",
+		"sub/b.bin":     "depth1",
+		"sub/sub2/c.bin": "depth2",
+	})
+
+	got, err := NewFinder(root).Name("*.bin").MaxDepth(1).All(context.Background())
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("All() with MaxDepth(1) = %v, want 2 entries (depth0 + depth1)", got)
+	}
+}
+
+func TestFinderFirstWithSeveralMatches(t *testing.T) {
+	root := mustMkTree(t, map[string]string{
+		"a/target.bin": "match",
+		"b/target.bin": "match",
+	})
+
+	got, err := NewFinder(root).Name("target.bin").First(context.Background())
+	if err != nil {
+		t.Fatalf("First: %v", err)
+	}
+	if got == "" {
+		t.Errorf("First() = %q, want a match", got)
+	}
+}
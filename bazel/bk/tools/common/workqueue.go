@@ -0,0 +1,183 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Job is one unit of work in a WorkQueue: an ID, the IDs of jobs it depends
+// on, and the function to run once those dependencies have completed
+// successfully. Run receives a buffer that collects everything the job
+// writes; the queue flushes it to the queue's Output as one contiguous block
+// once Run returns, so jobs running on different workers never interleave
+// their [bk_build]-style log lines.
+type Job struct {
+	ID   string
+	Deps []string
+	Run  func(ctx context.Context, out io.Writer) error
+}
+
+// WorkQueue runs a DAG of Jobs with up to Workers goroutines, honoring the
+// dependency edges declared in Job.Deps. It's a mutex+cond-guarded FIFO of
+// ready jobs fed by worker goroutines, modeled after Zig's own
+// ThreadSafeQueue: workers block on the condition variable when nothing is
+// ready and get woken once a dependency completes.
+type WorkQueue struct {
+	Workers int
+	Output  io.Writer
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     map[string]*Job
+	pending  map[string]int
+	waiters  map[string][]string
+	ready    []string
+	done     map[string]error
+	finished int
+}
+
+// NewWorkQueue creates an empty WorkQueue with the given worker count
+// (clamped to at least 1) and output sink for flushed job buffers.
+func NewWorkQueue(workers int, output io.Writer) *WorkQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &WorkQueue{Workers: workers, Output: output, jobs: make(map[string]*Job)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add registers a job. Deps may name jobs added before or after this call;
+// all jobs must be added before Run.
+func (q *WorkQueue) Add(j Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jj := j
+	q.jobs[j.ID] = &jj
+}
+
+// Run schedules every added job across q.Workers goroutines and blocks until
+// they've all finished, failed, or ctx was canceled. It returns the first job
+// error encountered, wrapped with the job's ID; jobs left downstream of a
+// failed dependency are skipped rather than run.
+func (q *WorkQueue) Run(parent context.Context) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	q.mu.Lock()
+	q.pending = make(map[string]int, len(q.jobs))
+	q.waiters = make(map[string][]string, len(q.jobs))
+	q.done = make(map[string]error, len(q.jobs))
+	q.finished = 0
+	q.ready = nil
+	for id, j := range q.jobs {
+		q.pending[id] = len(j.Deps)
+		for _, dep := range j.Deps {
+			q.waiters[dep] = append(q.waiters[dep], id)
+		}
+	}
+	for id, n := range q.pending {
+		if n == 0 {
+			q.ready = append(q.ready, id)
+		}
+	}
+	q.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+
+	for i := 0; i < q.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				id, j, ok := q.next(ctx)
+				if !ok {
+					return
+				}
+				var buf bytes.Buffer
+				err := j.Run(ctx, &buf)
+				q.finish(id, err, &buf)
+				if err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s: %w", id, err)
+					}
+					errMu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// next blocks until a ready job is available, the queue is fully drained, or
+// ctx is canceled with nothing left ready.
+func (q *WorkQueue) next(ctx context.Context) (string, *Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.ready) > 0 {
+			id := q.ready[0]
+			q.ready = q.ready[1:]
+			return id, q.jobs[id], true
+		}
+		if q.finished >= len(q.jobs) || ctx.Err() != nil {
+			return "", nil, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// finish records a job's outcome, flushes its buffered output, and either
+// unblocks dependents whose last dependency just succeeded or — on failure —
+// marks the whole downstream subtree as skipped so the queue can't deadlock
+// waiting on work that will never run.
+func (q *WorkQueue) finish(id string, err error, buf *bytes.Buffer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.done[id] = err
+	q.finished++
+	if q.Output != nil && buf.Len() > 0 {
+		q.Output.Write(buf.Bytes())
+	}
+
+	if err == nil {
+		for _, w := range q.waiters[id] {
+			q.pending[w]--
+			if q.pending[w] == 0 {
+				q.ready = append(q.ready, w)
+			}
+		}
+	} else {
+		q.skipDependents(id)
+	}
+	q.cond.Broadcast()
+}
+
+// skipDependents marks every job downstream of a failed job as done with a
+// "skipped" error, recursively, without running it.
+func (q *WorkQueue) skipDependents(id string) {
+	for _, w := range q.waiters[id] {
+		if _, already := q.done[w]; already {
+			continue
+		}
+		q.done[w] = fmt.Errorf("skipped: dependency %q failed", id)
+		q.finished++
+		q.skipDependents(w)
+	}
+}
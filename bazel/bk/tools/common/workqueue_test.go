@@ -0,0 +1,124 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueRunsInDependencyOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+
+	var out bytes.Buffer
+	q := NewWorkQueue(4, &out)
+	job := func(id string, deps ...string) Job {
+		return Job{ID: id, Deps: deps, Run: func(ctx context.Context, w io.Writer) error {
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			fmt.Fprintf(w, "[%s]\n", id)
+			return nil
+		}}
+	}
+	q.Add(job("fetch_ap"))
+	q.Add(job("fetch_cp"))
+	q.Add(job("build_ap", "fetch_ap"))
+	q.Add(job("build_cp", "fetch_cp"))
+	q.Add(job("armino", "build_ap", "build_cp"))
+
+	if err := q.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	pos := map[string]int{}
+	for i, id := range order {
+		pos[id] = i
+	}
+	if pos["armino"] <= pos["build_ap"] || pos["armino"] <= pos["build_cp"] {
+		t.Errorf("armino ran before its deps: order=%v", order)
+	}
+	if pos["build_ap"] <= pos["fetch_ap"] {
+		t.Errorf("build_ap ran before fetch_ap: order=%v", order)
+	}
+}
+
+func TestWorkQueueFlushesOutputPerJobWithoutInterleaving(t *testing.T) {
+	var out bytes.Buffer
+	q := NewWorkQueue(8, &out)
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("job%d", i)
+		q.Add(Job{ID: id, Run: func(ctx context.Context, w io.Writer) error {
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(w, "%s line %d\n", id, j)
+			}
+			return nil
+		}})
+	}
+
+	if err := q.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Every job's 50 lines must be contiguous in the flushed output — a job
+	// writing into its own buffer can never be split by another job's lines.
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("job%d", i)
+		block := fmt.Sprintf("%s line 0\n", id)
+		idx := bytes.Index(out.Bytes(), []byte(block))
+		if idx < 0 {
+			t.Fatalf("missing output for %s", id)
+		}
+		for j := 1; j < 50; j++ {
+			want := []byte(fmt.Sprintf("%s line %d\n", id, j))
+			got := out.Bytes()[idx+len(block) : idx+len(block)+len(want)]
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s output not contiguous: got %q, want %q", id, got, want)
+			}
+			block += string(want)
+		}
+	}
+}
+
+func TestWorkQueueSkipsDependentsOnFailure(t *testing.T) {
+	q := NewWorkQueue(2, &bytes.Buffer{})
+	q.Add(Job{ID: "a", Run: func(ctx context.Context, w io.Writer) error {
+		return fmt.Errorf("boom")
+	}})
+	var bRan bool
+	q.Add(Job{ID: "b", Deps: []string{"a"}, Run: func(ctx context.Context, w io.Writer) error {
+		bRan = true
+		return nil
+	}})
+
+	err := q.Run(context.Background())
+	if err == nil {
+		t.Fatal("Run: want error, got nil")
+	}
+	if bRan {
+		t.Error("b ran despite its dependency a failing")
+	}
+}
+
+func TestWorkQueueRespectsContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	q := NewWorkQueue(1, &bytes.Buffer{})
+	q.Add(Job{ID: "slow", Run: func(ctx context.Context, w io.Writer) error {
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}})
+
+	if err := q.Run(ctx); err == nil {
+		t.Error("Run: want error from canceled context, got nil")
+	}
+}
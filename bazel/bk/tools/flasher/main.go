@@ -1,29 +1,57 @@
 package main
 
 import (
-	"bufio"
-	"embed-zig/bazel/bk/tools/common"
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"strings"
+	"os/signal"
+	"time"
+
+	"embed-zig/bazel/bk/tools/common"
 )
 
 const prefix = "[bk_flash]"
 
 type Config struct {
-	Port       string
-	Baud       string
-	BkLoader   string
-	BinPath    string
-	ApBinPath  string
-	PartCSV    string
-	AppOnly    bool
+	Port      string
+	Baud      string
+	BkLoader  string
+	BinPath   string
+	ApBinPath string
+	PartCSV   string
+	AppOnly   bool
+	Verify    bool
+	Manifest  string
+	Chip      string
+}
+
+var subcommands = map[string]string{
+	"flash":  "Flash all-app.bin (or, with --app-only, just the AP partition). Default if no subcommand is given.",
+	"verify": "Read back primary_ap_app (or, without BK_AP_BIN/BK_PARTITIONS, all-app.bin) and compare SHA-256 against the local binary, without flashing.",
+	"swap":   "Flash the AP app to the inactive A/B slot, write an ota_info control record selecting it, then reboot.",
 }
 
 func main() {
-	appOnly := flag.Bool("app-only", false, "Flash AP partition only (skip bootloader/CP)")
-	flag.Parse()
+	sub := "flash"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := subcommands[args[0]]; ok {
+			sub = args[0]
+			args = args[1:]
+		} else if len(args[0]) > 0 && args[0][0] != '-' {
+			fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n\n", args[0])
+			printTopUsage()
+			os.Exit(1)
+		}
+	}
+
+	fs := flag.NewFlagSet(sub, flag.ExitOnError)
+	appOnly := fs.Bool("app-only", false, "Flash AP partition only (skip bootloader/CP)")
+	verify := fs.Bool("verify", false, "Read back and verify each flashed region against its source binary after flashing")
+	manifest := fs.String("manifest", "", "Write a JSON manifest record ({port, chip, slot, sha256, size, partitionOffset, timestamp, bkLoaderVersion}) to this path")
+	chip := fs.String("chip", getEnvDefault("BK_BOARD", "bk7258"), "Chip/board name recorded in -manifest")
+	fs.Parse(args)
 
 	cfg := Config{
 		Port:      os.Getenv("BK_PORT_CONFIG"),
@@ -33,6 +61,9 @@ func main() {
 		ApBinPath: os.Getenv("BK_AP_BIN"),
 		PartCSV:   os.Getenv("BK_PARTITIONS"),
 		AppOnly:   *appOnly,
+		Verify:    *verify,
+		Manifest:  *manifest,
+		Chip:      *chip,
 	}
 
 	// Find bk_loader
@@ -52,69 +83,136 @@ func main() {
 	// Kill existing process on port
 	common.KillPortProcess(cfg.Port, prefix)
 
-	if err := flash(&cfg); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch sub {
+	case "flash":
+		err = flash(ctx, &cfg)
+	case "verify":
+		err = runVerify(ctx, &cfg)
+	case "swap":
+		err = runSwap(ctx, &cfg)
+	}
+	if err != nil {
 		fatal(err)
 	}
 
 	fmt.Printf("%s Done!\n", prefix)
 }
 
-func flash(cfg *Config) error {
+func flash(ctx context.Context, cfg *Config) error {
+	var err error
 	if cfg.AppOnly && cfg.ApBinPath != "" && cfg.PartCSV != "" && common.FileExists(cfg.ApBinPath) && common.FileExists(cfg.PartCSV) {
-		return flashAppOnly(cfg)
+		err = flashAppOnly(ctx, cfg)
+	} else {
+		err = flashFull(ctx, cfg)
+	}
+	if err != nil {
+		return err
 	}
-	return flashFull(cfg)
+	if cfg.Verify {
+		if verr := verifyAfterFlash(ctx, cfg); verr != nil {
+			return verr
+		}
+	}
+	if cfg.Manifest != "" {
+		return writeFlashManifest(ctx, cfg)
+	}
+	return nil
 }
 
-func flashFull(cfg *Config) error {
+func flashFull(ctx context.Context, cfg *Config) error {
 	fmt.Printf("%s Flashing all-app.bin to %s (%s baud)\n", prefix, cfg.Port, cfg.Baud)
-	return common.RunCommand(cfg.BkLoader, "download",
-		"-p", cfg.Port,
-		"-b", cfg.Baud,
-		"--reset_baudrate", cfg.Baud,
-		"--reset_type", "1",
-		"-i", cfg.BinPath,
-		"--reboot")
+	_, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name: cfg.BkLoader,
+		Args: []string{"download",
+			"-p", cfg.Port,
+			"-b", cfg.Baud,
+			"--reset_baudrate", cfg.Baud,
+			"--reset_type", "1",
+			"-i", cfg.BinPath,
+			"--reboot"},
+		KillGracePeriod: 3 * time.Second,
+	})
+	return err
 }
 
-func flashAppOnly(cfg *Config) error {
+func flashAppOnly(ctx context.Context, cfg *Config) error {
 	// Read partition offset for primary_ap_app
-	offset, err := findAPOffset(cfg.PartCSV)
+	entries, err := parsePartitionCSV(cfg.PartCSV)
 	if err != nil {
 		return err
 	}
+	part, ok := findPartition(entries, "primary_ap_app")
+	if !ok {
+		return fmt.Errorf("cannot find primary_ap_app offset in partition table")
+	}
 
-	fmt.Printf("%s APP-ONLY (experimental): flashing AP to %s at offset %s (%s baud)\n", prefix, cfg.Port, offset, cfg.Baud)
-	fmt.Printf("%s WARNING: if device boot-loops, use full flash (without --app-only)\n", prefix)
+	fmt.Printf("%s APP-ONLY (experimental): flashing AP to %s at offset %s (%s baud)\n", prefix, cfg.Port, part.Offset, cfg.Baud)
+	fmt.Printf("%s WARNING: if device boot-loops, use full flash (without --app-only) or the safer `swap` subcommand\n", prefix)
 
-	return common.RunCommand(cfg.BkLoader, "download",
+	return downloadToOffset(ctx, cfg, cfg.ApBinPath, part.Offset, true)
+}
+
+// downloadToOffset flashes binPath to offset via bk_loader download,
+// optionally rebooting the device once the write completes. swap uses
+// reboot=false for the AP image (the reboot only happens after ota_info
+// is updated) and reboot=true for the ota_info write itself.
+func downloadToOffset(ctx context.Context, cfg *Config, binPath, offset string, reboot bool) error {
+	args := []string{"download",
 		"-p", cfg.Port,
 		"-b", cfg.Baud,
 		"--reset_baudrate", cfg.Baud,
 		"--reset_type", "1",
-		"-i", cfg.ApBinPath,
+		"-i", binPath,
 		"-s", offset,
-		"--reboot")
+	}
+	if reboot {
+		args = append(args, "--reboot")
+	}
+	_, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name:            cfg.BkLoader,
+		Args:            args,
+		KillGracePeriod: 3 * time.Second,
+	})
+	return err
 }
 
-func findAPOffset(partCSV string) (string, error) {
-	f, err := os.Open(partCSV)
-	if err != nil {
-		return "", fmt.Errorf("open partition table: %w", err)
+// verifyAfterFlash verifies whichever binary flash just wrote: the AP
+// partition for --app-only, or the combined all-app.bin at offset 0
+// otherwise.
+func verifyAfterFlash(ctx context.Context, cfg *Config) error {
+	if cfg.AppOnly && cfg.ApBinPath != "" && cfg.PartCSV != "" && common.FileExists(cfg.ApBinPath) && common.FileExists(cfg.PartCSV) {
+		entries, err := parsePartitionCSV(cfg.PartCSV)
+		if err != nil {
+			return err
+		}
+		part, ok := findPartition(entries, "primary_ap_app")
+		if !ok {
+			return fmt.Errorf("cannot find primary_ap_app offset in partition table")
+		}
+		_, _, err = verifyRegion(ctx, cfg, "primary_ap_app", cfg.ApBinPath, part.Offset)
+		return err
 	}
-	defer f.Close()
+	_, _, err := verifyRegion(ctx, cfg, "all-app.bin", cfg.BinPath, "0x0")
+	return err
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "primary_ap_app") {
-			parts := strings.Split(line, ",")
-			if len(parts) >= 2 {
-				return strings.TrimSpace(parts[1]), nil
+func writeFlashManifest(ctx context.Context, cfg *Config) error {
+	binPath, slot, offset := cfg.BinPath, "all-app", "0x0"
+	if cfg.AppOnly && cfg.ApBinPath != "" {
+		binPath, slot = cfg.ApBinPath, "primary_ap_app"
+		if cfg.PartCSV != "" {
+			if entries, err := parsePartitionCSV(cfg.PartCSV); err == nil {
+				if part, ok := findPartition(entries, "primary_ap_app"); ok {
+					offset = part.Offset
+				}
 			}
 		}
 	}
-	return "", fmt.Errorf("cannot find primary_ap_app offset in partition table")
+	rec := manifestRecord{Port: cfg.Port, Chip: cfg.Chip, Slot: slot, PartitionOffset: offset}
+	return writeManifest(ctx, cfg, cfg.Manifest, rec, binPath)
 }
 
 func getEnvDefault(key, defaultVal string) string {
@@ -124,6 +222,13 @@ func getEnvDefault(key, defaultVal string) string {
 	return defaultVal
 }
 
+func printTopUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: bk_flash [subcommand] [flags]\n\nSubcommands:\n")
+	for _, name := range []string{"flash", "verify", "swap"} {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, subcommands[name])
+	}
+}
+
 func fatal(err error) {
 	fmt.Fprintf(os.Stderr, "%s Error: %v\n", prefix, err)
 	os.Exit(1)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"embed-zig/bazel/bk/tools/common"
+)
+
+// manifestRecord is the -manifest JSON record describing what was just
+// flashed, for CI to track what's on a device across runs.
+type manifestRecord struct {
+	Port            string `json:"port"`
+	Chip            string `json:"chip"`
+	Slot            string `json:"slot"`
+	SHA256          string `json:"sha256"`
+	Size            int64  `json:"size"`
+	PartitionOffset string `json:"partitionOffset"`
+	Timestamp       string `json:"timestamp"`
+	BkLoaderVersion string `json:"bkLoaderVersion"`
+}
+
+// writeManifest computes rec's still-missing fields (hashing binPath if
+// rec.SHA256 is unset) and writes it as JSON to path.
+func writeManifest(ctx context.Context, cfg *Config, path string, rec manifestRecord, binPath string) error {
+	if rec.SHA256 == "" {
+		hash, err := common.Sha256File(binPath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(binPath)
+		if err != nil {
+			return err
+		}
+		rec.SHA256 = hash
+		rec.Size = info.Size()
+	}
+	rec.BkLoaderVersion = bkLoaderVersion(ctx, cfg)
+	rec.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// bkLoaderVersion best-effort queries cfg.BkLoader's own version string.
+// NOTE: assumes --version is supported, the same way verify.go assumes a
+// "read" subcommand; "unknown" is reported rather than failing the flash
+// over a manifest field that's metadata, not correctness-critical.
+func bkLoaderVersion(ctx context.Context, cfg *Config) string {
+	result, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name:          cfg.BkLoader,
+		Args:          []string{"--version"},
+		NoPassthrough: true,
+	})
+	if err != nil || result == nil {
+		return "unknown"
+	}
+	v := strings.TrimSpace(string(result.Stdout))
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
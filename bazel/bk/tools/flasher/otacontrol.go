@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// OTA control-record layout written to the "ota_info" partition by swap to
+// record which AP slot is active. There's no evidence in this tree of an
+// established bk OTA control-record format (the CSVs this tool reads only
+// ever named primary_ap_app before swap was added), so this is this tool's
+// own minimal format rather than a standard one:
+//
+//	offset 0: magic   "BKOT" (4 bytes)
+//	offset 4: version  1 byte, currently 1
+//	offset 5: active   1 byte, 0 = primary_ap_app, 1 = secondary_ap_app
+//	offset 6: reserved 6 bytes, zero
+//	offset 12: crc32   4 bytes, IEEE CRC-32 of bytes [0,12)
+const (
+	otaControlMagic   = "BKOT"
+	otaControlVersion = 1
+	otaControlSize    = 16
+)
+
+const (
+	slotPrimary   = 0
+	slotSecondary = 1
+)
+
+// encodeOTAControl builds an otaControlSize-byte control record selecting
+// activeSlot (slotPrimary or slotSecondary) as active.
+func encodeOTAControl(activeSlot byte) []byte {
+	buf := make([]byte, otaControlSize)
+	copy(buf[0:4], otaControlMagic)
+	buf[4] = otaControlVersion
+	buf[5] = activeSlot
+	crc := crc32.ChecksumIEEE(buf[0:12])
+	binary.LittleEndian.PutUint32(buf[12:16], crc)
+	return buf
+}
+
+// decodeOTAControl parses a control record written by encodeOTAControl,
+// returning the active slot. An empty/erased partition (all 0xFF, as a
+// blank NOR flash region reads) or anything that doesn't validate is
+// reported as an error rather than guessed at, so the caller can fall
+// back to its own default (see runSwap).
+func decodeOTAControl(data []byte) (byte, error) {
+	if len(data) < otaControlSize {
+		return 0, fmt.Errorf("ota_info region too short: got %d bytes, want %d", len(data), otaControlSize)
+	}
+	if string(data[0:4]) != otaControlMagic {
+		return 0, fmt.Errorf("ota_info has no valid control record (bad magic)")
+	}
+	if data[4] != otaControlVersion {
+		return 0, fmt.Errorf("ota_info control record has unsupported version %d", data[4])
+	}
+	wantCRC := binary.LittleEndian.Uint32(data[12:16])
+	gotCRC := crc32.ChecksumIEEE(data[0:12])
+	if wantCRC != gotCRC {
+		return 0, fmt.Errorf("ota_info control record CRC mismatch")
+	}
+	return data[5], nil
+}
+
+func slotName(slot byte) string {
+	if slot == slotSecondary {
+		return "secondary_ap_app"
+	}
+	return "primary_ap_app"
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestOTAControlRoundTrip(t *testing.T) {
+	for _, slot := range []byte{slotPrimary, slotSecondary} {
+		buf := encodeOTAControl(slot)
+		if len(buf) != otaControlSize {
+			t.Fatalf("encodeOTAControl(%d) len = %d, want %d", slot, len(buf), otaControlSize)
+		}
+		got, err := decodeOTAControl(buf)
+		if err != nil {
+			t.Fatalf("decodeOTAControl: %v", err)
+		}
+		if got != slot {
+			t.Errorf("decodeOTAControl(encodeOTAControl(%d)) = %d, want %d", slot, got, slot)
+		}
+	}
+}
+
+func TestDecodeOTAControlRejectsShortInput(t *testing.T) {
+	if _, err := decodeOTAControl(make([]byte, otaControlSize-1)); err == nil {
+		t.Error("decodeOTAControl(short input) = nil error, want error")
+	}
+}
+
+func TestDecodeOTAControlRejectsBadMagic(t *testing.T) {
+	buf := encodeOTAControl(slotPrimary)
+	copy(buf[0:4], "NOPE")
+	if _, err := decodeOTAControl(buf); err == nil {
+		t.Error("decodeOTAControl(bad magic) = nil error, want error")
+	}
+}
+
+func TestDecodeOTAControlRejectsBadVersion(t *testing.T) {
+	buf := encodeOTAControl(slotPrimary)
+	buf[4] = otaControlVersion + 1
+	if _, err := decodeOTAControl(buf); err == nil {
+		t.Error("decodeOTAControl(bad version) = nil error, want error")
+	}
+}
+
+func TestDecodeOTAControlRejectsBadCRC(t *testing.T) {
+	buf := encodeOTAControl(slotPrimary)
+	binary.LittleEndian.PutUint32(buf[12:16], binary.LittleEndian.Uint32(buf[12:16])+1)
+	if _, err := decodeOTAControl(buf); err == nil {
+		t.Error("decodeOTAControl(bad CRC) = nil error, want error")
+	}
+}
+
+func TestDecodeOTAControlRejectsErasedFlash(t *testing.T) {
+	buf := make([]byte, otaControlSize)
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+	if _, err := decodeOTAControl(buf); err == nil {
+		t.Error("decodeOTAControl(erased flash) = nil error, want error")
+	}
+}
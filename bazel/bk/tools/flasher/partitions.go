@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// partitionEntry is one row of a bk partition table CSV: name, flash
+// offset, and size, each as the raw string the table spells them with
+// (e.g. "0x110000"), since that's all bk_loader's -s/-i flags need.
+type partitionEntry struct {
+	Name   string
+	Offset string
+	Size   string
+}
+
+// parsePartitionCSV reads every "name,offset,size[,...]" row of a bk
+// partition table, skipping blank lines and '#'-prefixed comments.
+func parsePartitionCSV(path string) ([]partitionEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open partition table: %w", err)
+	}
+	defer f.Close()
+
+	var entries []partitionEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, partitionEntry{
+			Name:   strings.TrimSpace(fields[0]),
+			Offset: strings.TrimSpace(fields[1]),
+			Size:   strings.TrimSpace(fields[2]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// findPartition returns the entry named name, or ok=false if the table
+// has none.
+func findPartition(entries []partitionEntry, name string) (partitionEntry, bool) {
+	for _, e := range entries {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return partitionEntry{}, false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"embed-zig/bazel/bk/tools/common"
+)
+
+// runVerify reads back the partitions flash would have written and
+// compares them against the local binaries, without flashing anything.
+// Useful for confirming what's already on a device (e.g. after a manual
+// flash, or in a CI step separate from the one that flashed it).
+func runVerify(ctx context.Context, cfg *Config) error {
+	if cfg.ApBinPath != "" && cfg.PartCSV != "" && common.FileExists(cfg.ApBinPath) && common.FileExists(cfg.PartCSV) {
+		entries, err := parsePartitionCSV(cfg.PartCSV)
+		if err != nil {
+			return err
+		}
+		part, ok := findPartition(entries, "primary_ap_app")
+		if !ok {
+			return fmt.Errorf("cannot find primary_ap_app offset in partition table")
+		}
+		if _, _, err := verifyRegion(ctx, cfg, "primary_ap_app", cfg.ApBinPath, part.Offset); err != nil {
+			return err
+		}
+		if cfg.Manifest != "" {
+			rec := manifestRecord{Port: cfg.Port, Chip: cfg.Chip, Slot: "primary_ap_app", PartitionOffset: part.Offset}
+			return writeManifest(ctx, cfg, cfg.Manifest, rec, cfg.ApBinPath)
+		}
+		return nil
+	}
+	if cfg.BinPath != "" && common.FileExists(cfg.BinPath) {
+		if _, _, err := verifyRegion(ctx, cfg, "all-app.bin", cfg.BinPath, "0x0"); err != nil {
+			return err
+		}
+		if cfg.Manifest != "" {
+			rec := manifestRecord{Port: cfg.Port, Chip: cfg.Chip, Slot: "all-app", PartitionOffset: "0x0"}
+			return writeManifest(ctx, cfg, cfg.Manifest, rec, cfg.BinPath)
+		}
+		return nil
+	}
+	return fmt.Errorf("nothing to verify: set BK_AP_BIN + BK_PARTITIONS, or BK_BIN")
+}
+
+// runSwap flashes cfg.ApBinPath to whichever of the primary_ap_app/
+// secondary_ap_app A/B slots is currently inactive, writes an ota_info
+// control record selecting it as active, and only then reboots -- so a
+// device that loses power mid-flash just keeps booting its old, still-
+// intact slot, unlike --app-only's direct overwrite of primary_ap_app.
+func runSwap(ctx context.Context, cfg *Config) error {
+	if cfg.ApBinPath == "" || !common.FileExists(cfg.ApBinPath) {
+		return fmt.Errorf("swap needs BK_AP_BIN pointing at an existing AP app binary")
+	}
+	if cfg.PartCSV == "" || !common.FileExists(cfg.PartCSV) {
+		return fmt.Errorf("swap needs BK_PARTITIONS pointing at an existing partition table")
+	}
+
+	entries, err := parsePartitionCSV(cfg.PartCSV)
+	if err != nil {
+		return err
+	}
+	primary, ok := findPartition(entries, "primary_ap_app")
+	if !ok {
+		return fmt.Errorf("partition table has no primary_ap_app entry")
+	}
+	secondary, ok := findPartition(entries, "secondary_ap_app")
+	if !ok {
+		return fmt.Errorf("partition table has no secondary_ap_app entry; swap needs an A/B partition table (use --app-only for a single-slot table)")
+	}
+	otaInfo, ok := findPartition(entries, "ota_info")
+	if !ok {
+		return fmt.Errorf("partition table has no ota_info entry to record the active slot")
+	}
+
+	active := currentActiveSlot(ctx, cfg, otaInfo)
+	inactive := byte(slotPrimary)
+	inactivePart := primary
+	if active == slotPrimary {
+		inactive = slotSecondary
+		inactivePart = secondary
+	}
+	fmt.Printf("%s Active slot is %s; flashing AP to inactive slot %s at %s\n", prefix, slotName(active), slotName(inactive), inactivePart.Offset)
+
+	if err := downloadToOffset(ctx, cfg, cfg.ApBinPath, inactivePart.Offset, false); err != nil {
+		return fmt.Errorf("flash %s: %w", slotName(inactive), err)
+	}
+
+	var sha256 string
+	var size int64
+	if cfg.Verify {
+		sha256, size, err = verifyRegion(ctx, cfg, slotName(inactive), cfg.ApBinPath, inactivePart.Offset)
+		if err != nil {
+			return fmt.Errorf("aborting swap without updating ota_info: %w", err)
+		}
+	}
+
+	if err := writeOTAControl(ctx, cfg, otaInfo.Offset, inactive); err != nil {
+		return fmt.Errorf("write ota_info control record: %w", err)
+	}
+	fmt.Printf("%s ota_info now selects %s; device rebooting into it\n", prefix, slotName(inactive))
+
+	if cfg.Manifest != "" {
+		rec := manifestRecord{Port: cfg.Port, Chip: cfg.Chip, Slot: slotName(inactive), PartitionOffset: inactivePart.Offset, SHA256: sha256, Size: size}
+		return writeManifest(ctx, cfg, cfg.Manifest, rec, cfg.ApBinPath)
+	}
+	return nil
+}
+
+// currentActiveSlot reads ota_info back and decodes it, defaulting to
+// slotPrimary (treating the device as a fresh, never-swapped deployment)
+// when the region is unreadable or doesn't decode -- e.g. a blank,
+// erased ota_info partition before the first swap.
+func currentActiveSlot(ctx context.Context, cfg *Config, otaInfo partitionEntry) byte {
+	tmp, err := os.CreateTemp("", "bk_flash_ota_info_*.bin")
+	if err != nil {
+		return slotPrimary
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if err := readBkLoaderRegion(ctx, cfg, otaInfo.Offset, otaControlSize, tmpPath); err != nil {
+		fmt.Printf("%s could not read ota_info (%v); assuming fresh device, active=%s\n", prefix, err, slotName(slotPrimary))
+		return slotPrimary
+	}
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return slotPrimary
+	}
+	slot, err := decodeOTAControl(data)
+	if err != nil {
+		fmt.Printf("%s ota_info has no valid control record (%v); assuming fresh device, active=%s\n", prefix, err, slotName(slotPrimary))
+		return slotPrimary
+	}
+	return slot
+}
+
+// writeOTAControl flashes a fresh control record selecting activeSlot to
+// otaInfoOffset, via a temp file (bk_loader's only write path is
+// "download a file", there's no raw-bytes write in its assumed CLI).
+func writeOTAControl(ctx context.Context, cfg *Config, otaInfoOffset string, activeSlot byte) error {
+	tmp, err := os.CreateTemp("", "bk_flash_ota_write_*.bin")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	if _, err := tmp.Write(encodeOTAControl(activeSlot)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return downloadToOffset(ctx, cfg, tmpPath, otaInfoOffset, true)
+}
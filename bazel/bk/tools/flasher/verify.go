@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"embed-zig/bazel/bk/tools/common"
+)
+
+// readBkLoaderRegion reads length bytes starting at offset back from the
+// device into outPath, using bk_loader's read-back capability.
+//
+// NOTE: this assumes bk_loader has a "read" subcommand symmetric to the
+// "download" one flashFull/flashAppOnly already invoke -- same -p/-b/
+// --reset_baudrate/--reset_type flags, plus -s for the start offset, -l
+// for the length, and -o for the output file -- since nothing in this
+// tree shows bk_loader's actual read/dump flag names. If bk_loader's real
+// CLI differs, this is the one call site that needs updating.
+func readBkLoaderRegion(ctx context.Context, cfg *Config, offset string, length int, outPath string) error {
+	_, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name: cfg.BkLoader,
+		Args: []string{"read",
+			"-p", cfg.Port,
+			"-b", cfg.Baud,
+			"--reset_baudrate", cfg.Baud,
+			"--reset_type", "1",
+			"-s", offset,
+			"-l", strconv.Itoa(length),
+			"-o", outPath,
+		},
+		KillGracePeriod: 3 * time.Second,
+	})
+	return err
+}
+
+// verifyRegion reads back the partition at offset and compares its
+// SHA-256 against srcPath, returning the matched SHA-256 and size on
+// success. label is used only for log/error messages (e.g. "primary_ap_app").
+func verifyRegion(ctx context.Context, cfg *Config, label, srcPath, offset string) (sha256 string, size int64, err error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("stat %s: %w", srcPath, err)
+	}
+
+	tmp, err := os.CreateTemp("", "bk_flash_verify_*.bin")
+	if err != nil {
+		return "", 0, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	fmt.Printf("%s Reading back %s (%d bytes @ %s) to verify...\n", prefix, label, info.Size(), offset)
+	if err := readBkLoaderRegion(ctx, cfg, offset, int(info.Size()), tmpPath); err != nil {
+		return "", 0, fmt.Errorf("read back %s: %w", label, err)
+	}
+
+	wantHash, err := common.Sha256File(srcPath)
+	if err != nil {
+		return "", 0, err
+	}
+	gotHash, err := common.Sha256File(tmpPath)
+	if err != nil {
+		return "", 0, err
+	}
+	if wantHash != gotHash {
+		return "", 0, fmt.Errorf("%s verify FAILED: flashed sha256=%s does not match source sha256=%s", label, gotHash, wantHash)
+	}
+	fmt.Printf("%s %s verified OK (sha256=%s)\n", prefix, label, wantHash)
+	return wantHash, info.Size(), nil
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"embed-zig/bazel/bk/tools/common"
 	"fmt"
 	"os"
-	"os/exec"
+
+	"embed-zig/bazel/bk/tools/common"
+	"embed-zig/bazel/common/serial"
 )
 
 const prefix = "[bk_monitor]"
@@ -20,36 +21,14 @@ func main() {
 
 	fmt.Printf("%s Board: BK7258\n", prefix)
 	fmt.Printf("%s Monitoring %s at 115200 baud...\n", prefix, port)
-	fmt.Println(prefix + " Press Ctrl+C to exit")
-
-	// Pass port via sys.argv to avoid string injection in Python code
-	pythonCode := `
-import serial, sys
-port = sys.argv[1]
-try:
-    ser = serial.Serial(port, 115200, timeout=0.5)
-    ser.setDTR(False)
-    ser.setRTS(False)
-    print(f'Connected to {port} at 115200 baud')
-    print('Waiting for data... (press RST on device if needed)')
-    print('---')
-    while True:
-        data = ser.read(ser.in_waiting or 1)
-        if data:
-            sys.stdout.write(data.decode('utf-8', errors='replace'))
-            sys.stdout.flush()
-except KeyboardInterrupt:
-    print('\n--- Monitor stopped ---')
-except Exception as e:
-    print(f'Error: {e}')
-    sys.exit(1)
-`
-
-	cmd := exec.Command("python3", "-c", pythonCode, port)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
+	fmt.Println(prefix + " Press Ctrl+] to exit")
+
+	opts := serial.MonitorOptions{
+		ResetOnConnect: true,
+		LogPath:        os.Getenv("BK_MONITOR_LOG"),
+	}
+
+	if err := serial.Monitor(port, 115200, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "%s Error: Monitor failed: %v\n", prefix, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,360 @@
+// Package serial implements a native Go serial terminal, shared by the bk and
+// esp monitor tools so neither depends on a Python venv at runtime.
+package serial
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	goserial "go.bug.st/serial"
+)
+
+// quitByte is the Ctrl-] control character used to exit the monitor, matching
+// the convention of picocom/miniterm that board-bringup engineers already know.
+const quitByte = 0x1d
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiGray   = "\x1b[90m"
+	ansiReset  = "\x1b[0m"
+)
+
+// MonitorOptions configures a Monitor session.
+type MonitorOptions struct {
+	// ResetOnConnect toggles DTR/RTS low on open to trigger a board reset,
+	// mirroring the old `ser.setDTR(False); ser.setRTS(False)` pyserial dance.
+	ResetOnConnect bool
+
+	// LogPath, if set, tees all received bytes to this file in append mode.
+	LogPath string
+
+	// ScrollbackLines bounds an in-memory ring buffer of decoded lines
+	// (0 disables it), for --//bazel:monitor_scrollback=N.
+	ScrollbackLines int
+
+	// Timestamps prefixes each received line with a "HH:MM:SS.mmm " receive
+	// timestamp, for --timestamps.
+	Timestamps bool
+
+	// Colorize color-codes each received line by its ESP-IDF log level
+	// (E/W/D/V; I and unrecognized lines pass through uncolored), for
+	// --no-color's opposite default. Independent of the red Guru Meditation
+	// Error coloring a Symbolizer applies when wrapping Stdout.
+	Colorize bool
+
+	// Stdout and Stdin default to os.Stdout/os.Stdin; overridable for tests.
+	Stdout io.Writer
+	Stdin  io.Reader
+}
+
+// Scrollback is a bounded ring buffer of the most recently seen lines.
+type Scrollback struct {
+	lines []string
+	max   int
+	buf   []byte
+}
+
+func newScrollback(max int) *Scrollback {
+	return &Scrollback{max: max}
+}
+
+func (s *Scrollback) Write(p []byte) (int, error) {
+	if s == nil || s.max <= 0 {
+		return len(p), nil
+	}
+	s.buf = append(s.buf, p...)
+	for {
+		i := indexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		s.push(string(s.buf[:i]))
+		s.buf = s.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *Scrollback) push(line string) {
+	s.lines = append(s.lines, line)
+	if len(s.lines) > s.max {
+		s.lines = s.lines[len(s.lines)-s.max:]
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (s *Scrollback) Lines() []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s.lines...)
+}
+
+func indexByte(b []byte, c byte) int {
+	for i, v := range b {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Monitor opens port at baud and streams it to stdout until the connection
+// closes, Ctrl-] is read from stdin, or an unrecoverable I/O error occurs.
+func Monitor(port string, baud int, opts MonitorOptions) error {
+	mode := &goserial.Mode{BaudRate: baud}
+	p, err := goserial.Open(port, mode)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	if opts.ResetOnConnect {
+		_ = p.SetDTR(false)
+		_ = p.SetRTS(false)
+	}
+
+	return run(p, p, opts)
+}
+
+const (
+	reconnectMinDelay    = 500 * time.Millisecond
+	reconnectMaxDelay    = 5 * time.Second
+	reconnectStableAfter = 10 * time.Second
+)
+
+// MonitorReconnecting wraps Monitor in a retry loop: when the port
+// disappears mid-session (USB replug, or a board reset that drops the CDC
+// endpoint) Monitor returns an error instead of the nil a user's Ctrl-]
+// produces, and this re-opens the port after a short backoff instead of
+// exiting. The backoff starts at reconnectMinDelay and doubles up to
+// reconnectMaxDelay, resetting once a session has stayed open past
+// reconnectStableAfter, so a board that's been running fine for a while
+// doesn't inherit a stale delay from an earlier blip.
+func MonitorReconnecting(port string, baud int, opts MonitorOptions) error {
+	return monitorReconnecting(port, baud, opts, Monitor, time.Sleep)
+}
+
+// monitorReconnecting is MonitorReconnecting with monitor/sleep as
+// parameters, so tests can exercise the backoff logic without a real serial
+// port or real delays, the same way run is split out of Monitor.
+func monitorReconnecting(port string, baud int, opts MonitorOptions, monitor func(string, int, MonitorOptions) error, sleep func(time.Duration)) error {
+	delay := reconnectMinDelay
+	for {
+		start := time.Now()
+		err := monitor(port, baud, opts)
+		if err == nil {
+			return nil
+		}
+		if time.Since(start) >= reconnectStableAfter {
+			delay = reconnectMinDelay
+		}
+		fmt.Fprintf(os.Stderr, "[serial] %s disappeared (%v); reconnecting in %s...\n", port, err, delay)
+		sleep(delay)
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// run drives the read/quit loop against rw and is split out from Monitor so
+// tests can substitute an io.Pipe for the real serial port.
+func run(r io.Reader, w io.Writer, opts MonitorOptions) error {
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if opts.Timestamps {
+		stdout = newTimestampWriter(stdout)
+	}
+	if opts.Colorize {
+		stdout = newColorizeWriter(stdout)
+	}
+	stdin := opts.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+
+	var logFile *os.File
+	if opts.LogPath != "" {
+		f, err := os.OpenFile(opts.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		logFile = f
+	}
+
+	scrollback := newScrollback(opts.ScrollbackLines)
+
+	quit := make(chan struct{})
+	go watchForQuit(stdin, quit)
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- copyDecoded(stdout, logFile, scrollback, r)
+	}()
+
+	select {
+	case <-quit:
+		return nil
+	case err := <-readErr:
+		return err
+	}
+}
+
+// watchForQuit reads single bytes from stdin and signals quit on Ctrl-].
+func watchForQuit(stdin io.Reader, quit chan<- struct{}) {
+	buf := make([]byte, 1)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 && buf[0] == quitByte {
+			close(quit)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// copyDecoded streams r to stdout (and optionally a log tee + scrollback
+// buffer), decoding as UTF-8 with the replacement character for invalid
+// sequences and passing ANSI escape codes through untouched.
+func copyDecoded(stdout io.Writer, tee io.Writer, scrollback *Scrollback, r io.Reader) error {
+	writers := []io.Writer{stdout}
+	if tee != nil {
+		writers = append(writers, tee)
+	}
+	if scrollback != nil {
+		writers = append(writers, scrollback)
+	}
+	dst := io.MultiWriter(writers...)
+
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			clean, rest := decodeUTF8Prefix(pending)
+			if len(clean) > 0 {
+				if _, werr := dst.Write(clean); werr != nil {
+					return werr
+				}
+			}
+			pending = rest
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// timestampWriter prefixes each complete line written to it with a
+// "HH:MM:SS.mmm " receive timestamp. Partial lines are buffered until their
+// newline arrives, matching Scrollback's own line-buffering.
+type timestampWriter struct {
+	out io.Writer
+	buf []byte
+	now func() time.Time
+}
+
+func newTimestampWriter(out io.Writer) *timestampWriter {
+	return &timestampWriter{out: out, now: time.Now}
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := indexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i+1]
+		w.buf = w.buf[i+1:]
+		stamped := append([]byte(w.now().Format("15:04:05.000 ")), line...)
+		if _, err := w.out.Write(stamped); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// logLevelRe matches an ESP-IDF log line's level letter, e.g. "E (1234)
+// wifi: ...".
+var logLevelRe = regexp.MustCompile(`^([EWDV]) \(\d+\)`)
+
+// logLevelColor maps an ESP-IDF log level letter to its ANSI color; I and
+// any unrecognized prefix pass through uncolored.
+var logLevelColor = map[byte]string{
+	'E': ansiRed,
+	'W': ansiYellow,
+	'D': ansiCyan,
+	'V': ansiGray,
+}
+
+// colorizeWriter wraps each complete line in the ANSI color for its
+// ESP-IDF log level, matching one full line per underlying Write call so it
+// composes cleanly whether it wraps or is wrapped by timestampWriter.
+type colorizeWriter struct {
+	out io.Writer
+	buf []byte
+}
+
+func newColorizeWriter(out io.Writer) *colorizeWriter {
+	return &colorizeWriter{out: out}
+}
+
+func (w *colorizeWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := indexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i+1]
+		w.buf = w.buf[i+1:]
+
+		out := line
+		if m := logLevelRe.FindSubmatch(line); m != nil {
+			if color, ok := logLevelColor[m[1][0]]; ok {
+				out = append(append([]byte(color), line...), []byte(ansiReset)...)
+			}
+		}
+		if _, err := w.out.Write(out); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// decodeUTF8Prefix returns the longest valid, replacement-decoded prefix of
+// buf and the remaining bytes that might be the start of a multi-byte
+// sequence still arriving on the wire.
+func decodeUTF8Prefix(buf []byte) (clean, rest []byte) {
+	for len(buf) > 0 {
+		r, size := utf8.DecodeRune(buf)
+		if r == utf8.RuneError && size <= 1 {
+			if !utf8.FullRune(buf) {
+				// Might be a truncated valid sequence; wait for more bytes.
+				break
+			}
+			clean = append(clean, []byte(string(utf8.RuneError))...)
+			buf = buf[1:]
+			continue
+		}
+		clean = append(clean, buf[:size]...)
+		buf = buf[size:]
+	}
+	return clean, buf
+}
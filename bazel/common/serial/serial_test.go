@@ -0,0 +1,133 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyDecodedPassesThroughASCIIAndANSI(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("hello\x1b[31mworld\x1b[0m\n"))
+		w.Close()
+	}()
+
+	var out bytes.Buffer
+	if err := copyDecoded(&out, nil, nil, r); err != nil {
+		t.Fatalf("copyDecoded: %v", err)
+	}
+	if got := out.String(); got != "hello\x1b[31mworld\x1b[0m\n" {
+		t.Errorf("copyDecoded output = %q", got)
+	}
+}
+
+func TestCopyDecodedReplacesInvalidUTF8(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte{'o', 'k', 0xff, 0xfe, '\n'})
+		w.Close()
+	}()
+
+	var out bytes.Buffer
+	if err := copyDecoded(&out, nil, nil, r); err != nil {
+		t.Fatalf("copyDecoded: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "ok") {
+		t.Errorf("copyDecoded output = %q, want ok-prefixed", out.String())
+	}
+	if !strings.Contains(out.String(), "�") {
+		t.Errorf("copyDecoded output = %q, want replacement char", out.String())
+	}
+}
+
+func TestCopyDecodedTeesToLogAndScrollback(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("line one\nline two\n"))
+		w.Close()
+	}()
+
+	var out, tee bytes.Buffer
+	sb := newScrollback(1)
+	if err := copyDecoded(&out, &tee, sb, r); err != nil {
+		t.Fatalf("copyDecoded: %v", err)
+	}
+	if tee.String() != out.String() {
+		t.Errorf("tee = %q, want equal to stdout %q", tee.String(), out.String())
+	}
+	if got := sb.Lines(); len(got) != 1 || got[0] != "line two" {
+		t.Errorf("scrollback.Lines() = %v, want [\"line two\"]", got)
+	}
+}
+
+func TestRunQuitsOnCtrlRightBracket(t *testing.T) {
+	portR, portW := io.Pipe()
+	defer portW.Close()
+	stdin := strings.NewReader(string(rune(quitByte)))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(portR, portW, MonitorOptions{Stdout: io.Discard, Stdin: stdin})
+	}()
+
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+}
+
+func TestTimestampWriterPrefixesCompleteLines(t *testing.T) {
+	var out bytes.Buffer
+	w := newTimestampWriter(&out)
+	w.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	if _, err := w.Write([]byte("line one\nline two\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := "12:00:00.000 line one\n12:00:00.000 line two\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestColorizeWriterColorsByLogLevel(t *testing.T) {
+	var out bytes.Buffer
+	w := newColorizeWriter(&out)
+
+	if _, err := w.Write([]byte("E (123) wifi: boom\nI (124) wifi: ok\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := ansiRed + "E (123) wifi: boom\n" + ansiReset + "I (124) wifi: ok\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestMonitorReconnectingRetriesThenSucceeds(t *testing.T) {
+	openErr := errors.New("port disappeared")
+	attempts := 0
+	fakeMonitor := func(port string, baud int, opts MonitorOptions) error {
+		attempts++
+		if attempts < 3 {
+			return openErr
+		}
+		return nil
+	}
+
+	var delays []time.Duration
+	fakeSleep := func(d time.Duration) { delays = append(delays, d) }
+
+	if err := monitorReconnecting("/dev/fake", 115200, MonitorOptions{}, fakeMonitor, fakeSleep); err != nil {
+		t.Fatalf("monitorReconnecting: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	wantDelays := []time.Duration{reconnectMinDelay, reconnectMinDelay * 2}
+	if len(delays) != len(wantDelays) || delays[0] != wantDelays[0] || delays[1] != wantDelays[1] {
+		t.Errorf("delays = %v, want %v", delays, wantDelays)
+	}
+}
@@ -0,0 +1,484 @@
+// Package toolchain unifies Armino SDK and ESP-IDF discovery behind a single
+// Resolve call, replacing the separate (and subtly buggy) env-var lookups
+// that used to live in bk/tools/common.SetupArminoEnv and
+// esp/tools/common.SetupIDFEnv.
+//
+// ESP-IDF resolution prefers an ESP_IDF_TOOLS_JSON manifest (the output of
+// `idf_tools.py export --format=json`) over scanning the filesystem: under
+// Bazel remote execution $HOME is often empty or unwritable, so a manifest
+// produced once outside the sandbox is the only hermetic option. Parsed
+// manifests are cached content-addressed under ToolchainSpec.WorkDir.
+package toolchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Kind selects which SDK a Toolchain resolves.
+type Kind string
+
+const (
+	Armino Kind = "armino"
+	ESPIDF Kind = "esp-idf"
+)
+
+// ToolchainSpec describes how to locate a toolchain for one driver invocation.
+type ToolchainSpec struct {
+	Kind Kind
+
+	// BazelFlag is the value of the corresponding --//bazel:armino_path or
+	// --//bazel:idf_path flag, if the caller passed one. It wins over every
+	// other source except, for ESP-IDF, an ESP_IDF_TOOLS_JSON manifest.
+	BazelFlag string
+
+	// WorkspaceRoot is where .embed-zig.toml is looked for (usually the
+	// Bazel workspace root); empty skips the config-file step.
+	WorkspaceRoot string
+
+	// ToolPrefix is used for log messages, e.g. "[bk_build]".
+	ToolPrefix string
+
+	// WorkDir is ESP_WORK_DIR, the Bazel sandbox work directory. When set,
+	// a parsed ESP_IDF_TOOLS_JSON manifest (see resolveESPIDFFromManifest)
+	// is cached under it, keyed by the manifest's own content, so repeated
+	// invocations in the same sandbox don't re-parse it. Empty just skips
+	// the cache.
+	WorkDir string
+}
+
+// Toolchain is a resolved SDK install, ready to hand a prebuilt environment
+// to exec.Cmd without each driver re-deriving PATH/python itself.
+type Toolchain struct {
+	Kind Kind
+	Root string // ARMINO_PATH or IDF_PATH
+	Home string // HOME used to locate ~/.espressif, ~/armino
+
+	python   string
+	binPaths []string
+}
+
+// config mirrors the [toolchain] table of .embed-zig.toml:
+//
+//	[toolchain]
+//	armino_path = "/opt/bk_avdk_smp"
+//	idf_path = "/opt/esp-idf"
+type config struct {
+	Toolchain struct {
+		ArminoPath string `toml:"armino_path"`
+		IDFPath    string `toml:"idf_path"`
+	} `toml:"toolchain"`
+}
+
+// loadConfig reads .embed-zig.toml at root, returning a zero config if the
+// file doesn't exist.
+func loadConfig(root string) (config, error) {
+	var cfg config
+	if root == "" {
+		return cfg, nil
+	}
+	path := filepath.Join(root, ".embed-zig.toml")
+	if _, err := os.Stat(path); err != nil {
+		return cfg, nil
+	}
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Resolve locates a Toolchain by consulting, in order: spec.BazelFlag, the
+// workspace's .embed-zig.toml, ARMINO_PATH/IDF_PATH, and finally a
+// platform-specific default (~/armino, ~/.espressif). For ESP-IDF, an
+// ESP_IDF_TOOLS_JSON manifest, when set, is used before any of these.
+func Resolve(spec ToolchainSpec) (*Toolchain, error) {
+	cfg, err := loadConfig(spec.WorkspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+
+	switch spec.Kind {
+	case Armino:
+		return resolveArmino(spec, cfg, home)
+	case ESPIDF:
+		return resolveESPIDF(spec, cfg, home)
+	default:
+		return nil, fmt.Errorf("toolchain: unknown kind %q", spec.Kind)
+	}
+}
+
+func resolveArmino(spec ToolchainSpec, cfg config, home string) (*Toolchain, error) {
+	root := spec.BazelFlag
+	if root == "" {
+		root = cfg.Toolchain.ArminoPath
+	}
+	if root == "" {
+		root = os.Getenv("ARMINO_PATH")
+	}
+	if root == "" {
+		root = filepath.Join(home, "armino")
+	}
+
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("ARMINO_PATH=%s does not exist\nAdd to .bazelrc.user:\n  build --//bazel:armino_path=/path/to/bk_avdk_smp", root)
+	}
+
+	tc := &Toolchain{Kind: Armino, Root: root, Home: home}
+
+	venvBin := filepath.Join(root, "venv", "bin")
+	if _, err := os.Stat(filepath.Join(venvBin, "activate")); err == nil {
+		tc.python = filepath.Join(venvBin, "python")
+		tc.binPaths = append(tc.binPaths, venvBin)
+	}
+
+	fmt.Printf("%s Armino SDK: %s\n", spec.ToolPrefix, root)
+	return tc, nil
+}
+
+// idfEnvVersion matches idfX.Y_env / idfX.Y.Z_env directories as produced by
+// idf_tools.py, e.g. "idf5.1_env" or "idf5.10.1_env".
+var idfEnvVersion = regexp.MustCompile(`^idf(\d+(?:\.\d+)*)_env$`)
+
+func resolveESPIDF(spec ToolchainSpec, cfg config, home string) (*Toolchain, error) {
+	if tc, err := resolveESPIDFFromManifest(spec); err != nil {
+		return nil, err
+	} else if tc != nil {
+		tc.Home = home
+		return tc, nil
+	}
+
+	root := spec.BazelFlag
+	if root == "" {
+		root = cfg.Toolchain.IDFPath
+	}
+	if root == "" {
+		root = os.Getenv("IDF_PATH")
+	}
+	if root == "" {
+		root = filepath.Join(home, ".espressif", "esp-idf")
+	}
+
+	tc := &Toolchain{Kind: ESPIDF, Root: root, Home: home}
+
+	pythonEnvDir := filepath.Join(home, ".espressif", "python_env")
+	envDir, err := highestIDFEnv(pythonEnvDir)
+	if err != nil {
+		return nil, err
+	}
+	if envDir == "" {
+		fmt.Printf("%s Warning: ESP-IDF Python env not found, using system python3\n", spec.ToolPrefix)
+		tc.python = "python3"
+		return tc, nil
+	}
+
+	fmt.Printf("%s Using Python env: %s\n", spec.ToolPrefix, envDir)
+	tc.python = filepath.Join(envDir, "bin", "python")
+	tc.binPaths = append(tc.binPaths, filepath.Join(envDir, "bin"))
+
+	espressifTools := filepath.Join(home, ".espressif", "tools")
+	tc.binPaths = append(tc.binPaths, scanToolBinDirs(espressifTools)...)
+
+	if root != "" {
+		tc.binPaths = append(tc.binPaths, filepath.Join(root, "tools"))
+	}
+
+	return tc, nil
+}
+
+// scanToolBinDirs finds every "bin" directory up to three levels under root
+// (tool/bin, tool/version/bin, or tool/version/platform/bin — matching the
+// depth idf_tools.py actually installs at) using one os.ReadDir per level
+// instead of a recursive filepath.Walk. That matters under Bazel remote
+// execution, where $HOME/.espressif/tools can hold many large per-platform
+// tool trees a full walk would needlessly descend into.
+func scanToolBinDirs(root string) []string {
+	var bins []string
+	level1, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	for _, e1 := range level1 {
+		if !e1.IsDir() {
+			continue
+		}
+		p1 := filepath.Join(root, e1.Name())
+		if hasBinDir(p1, &bins) {
+			continue
+		}
+		level2, err := os.ReadDir(p1)
+		if err != nil {
+			continue
+		}
+		for _, e2 := range level2 {
+			if !e2.IsDir() {
+				continue
+			}
+			p2 := filepath.Join(p1, e2.Name())
+			if hasBinDir(p2, &bins) {
+				continue
+			}
+			level3, err := os.ReadDir(p2)
+			if err != nil {
+				continue
+			}
+			for _, e3 := range level3 {
+				if !e3.IsDir() {
+					continue
+				}
+				hasBinDir(filepath.Join(p2, e3.Name()), &bins)
+			}
+		}
+	}
+	return bins
+}
+
+// hasBinDir appends dir/bin to bins and reports true if it exists and is a
+// directory, so scanToolBinDirs can stop descending once it's found one.
+func hasBinDir(dir string, bins *[]string) bool {
+	bin := filepath.Join(dir, "bin")
+	info, err := os.Stat(bin)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	*bins = append(*bins, bin)
+	return true
+}
+
+// idfManifestEnv is one entry of the "env" array idf_tools.py export
+// --format=json produces.
+type idfManifestEnv struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// idfManifest is the subset of idf_tools.py export --format=json this
+// package reads: PATH, IDF_PATH, and IDF_PYTHON_ENV_PATH.
+type idfManifest struct {
+	Env []idfManifestEnv `json:"env"`
+}
+
+// manifestKeys are the idfManifest.Env entries resolveESPIDFFromManifest
+// actually needs, and the order they're written to the cache file in.
+var manifestKeys = []string{"IDF_PATH", "PATH", "IDF_PYTHON_ENV_PATH", "IDF_PYTHON"}
+
+// resolveESPIDFFromManifest builds a Toolchain from the manifest named by
+// ESP_IDF_TOOLS_JSON (the output of `idf_tools.py export --format=json`),
+// returning (nil, nil) if that env var isn't set so the caller falls back to
+// filesystem discovery. This is the hermetic path: under Bazel remote
+// execution $HOME is often empty or unwritable, so scanning
+// ~/.espressif/tools isn't an option, but a manifest produced once outside
+// the sandbox and passed in by path always is.
+func resolveESPIDFFromManifest(spec ToolchainSpec) (*Toolchain, error) {
+	manifestPath := os.Getenv("ESP_IDF_TOOLS_JSON")
+	if manifestPath == "" {
+		return nil, nil
+	}
+
+	vals, err := loadIDFManifest(spec, manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("ESP_IDF_TOOLS_JSON=%s: %w", manifestPath, err)
+	}
+
+	fmt.Printf("%s Using ESP-IDF manifest: %s\n", spec.ToolPrefix, manifestPath)
+	tc := &Toolchain{Kind: ESPIDF, Root: vals["IDF_PATH"], python: vals["IDF_PYTHON"]}
+	if p := vals["PATH"]; p != "" {
+		tc.binPaths = strings.Split(p, string(os.PathListSeparator))
+	}
+	return tc, nil
+}
+
+// loadIDFManifest parses manifestPath into the key/value pairs
+// resolveESPIDFFromManifest needs, consulting (and populating) a
+// content-addressed cache file under spec.WorkDir first so a manifest that
+// hasn't changed since the last invocation isn't re-parsed.
+func loadIDFManifest(spec ToolchainSpec, manifestPath string) (map[string]string, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cachePath string
+	if spec.WorkDir != "" {
+		cachePath = idfManifestCachePath(spec.WorkDir, data)
+		if vals, err := readIDFManifestCache(cachePath); err == nil {
+			return vals, nil
+		}
+	}
+
+	var manifest idfManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	vals := make(map[string]string, len(manifestKeys))
+	for _, e := range manifest.Env {
+		switch e.Name {
+		case "IDF_PATH", "PATH", "IDF_PYTHON_ENV_PATH":
+			vals[e.Name] = e.Value
+		}
+	}
+	if envPath := vals["IDF_PYTHON_ENV_PATH"]; envPath != "" {
+		vals["IDF_PYTHON"] = filepath.Join(envPath, "bin", "python")
+	}
+
+	if cachePath != "" {
+		if err := writeIDFManifestCache(cachePath, vals); err != nil {
+			fmt.Fprintf(os.Stderr, "%s Warning: failed to cache ESP-IDF manifest: %v\n", spec.ToolPrefix, err)
+		}
+	}
+	return vals, nil
+}
+
+// idfManifestCachePath names the cache file for manifestData's content under
+// workDir, so editing or regenerating the manifest invalidates the cache
+// instead of resolveESPIDFFromManifest silently reusing stale paths.
+func idfManifestCachePath(workDir string, manifestData []byte) string {
+	sum := sha256.Sum256(manifestData)
+	return filepath.Join(workDir, ".toolchain-cache", "esp-idf-"+hex.EncodeToString(sum[:])[:16]+".txt")
+}
+
+// readIDFManifestCache parses a cache file written by writeIDFManifestCache.
+func readIDFManifestCache(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vals := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vals[k] = v
+	}
+	return vals, nil
+}
+
+// writeIDFManifestCache writes vals to path in manifestKeys order, one
+// key=value per line.
+func writeIDFManifestCache(path string, vals map[string]string) error {
+	var b strings.Builder
+	b.WriteString("# esp-idf toolchain cache — parsed from an ESP_IDF_TOOLS_JSON manifest.\n")
+	for _, k := range manifestKeys {
+		if v, ok := vals[k]; ok {
+			fmt.Fprintf(&b, "%s=%s\n", k, v)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// highestIDFEnv picks the idfX.Y[.Z]_env directory under pythonEnvDir with
+// the highest semver-compared version, or "" if none is found.
+func highestIDFEnv(pythonEnvDir string) (string, error) {
+	entries, err := os.ReadDir(pythonEnvDir)
+	if err != nil {
+		return "", nil // no python_env dir yet: not an error, just unresolved
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !idfEnvVersion.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(pythonEnvDir, entry.Name(), "bin", "python")); err != nil {
+			continue
+		}
+		candidates = append(candidates, entry.Name())
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return compareIDFVersions(candidates[i], candidates[j]) < 0
+	})
+	best := candidates[len(candidates)-1]
+	return filepath.Join(pythonEnvDir, best), nil
+}
+
+// compareIDFVersions compares two idfX.Y[.Z]_env names numerically,
+// component by component, so "idf5.2_env" sorts above "idf5.10_env" is
+// avoided: 10 > 2 numerically even though "1" < "2" lexically.
+func compareIDFVersions(a, b string) int {
+	va := idfEnvVersion.FindStringSubmatch(a)
+	vb := idfEnvVersion.FindStringSubmatch(b)
+	if va == nil || vb == nil {
+		return strings.Compare(a, b)
+	}
+
+	pa := strings.Split(va[1], ".")
+	pb := strings.Split(vb[1], ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
+
+// Python returns the resolved Python interpreter path.
+func (t *Toolchain) Python() string {
+	return t.python
+}
+
+// BinPaths returns the directories that should be prepended to PATH, in
+// priority order, for this toolchain.
+func (t *Toolchain) BinPaths() []string {
+	return t.binPaths
+}
+
+// Env returns a PATH-augmented copy of the current process environment,
+// ready to assign to exec.Cmd.Env.
+func (t *Toolchain) Env() []string {
+	env := os.Environ()
+	if len(t.binPaths) == 0 {
+		return env
+	}
+
+	newPath := strings.Join(t.binPaths, string(os.PathListSeparator)) + string(os.PathListSeparator) + os.Getenv("PATH")
+	out := make([]string, 0, len(env)+1)
+	replaced := false
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			out = append(out, "PATH="+newPath)
+			replaced = true
+			continue
+		}
+		out = append(out, kv)
+	}
+	if !replaced {
+		out = append(out, "PATH="+newPath)
+	}
+	return out
+}
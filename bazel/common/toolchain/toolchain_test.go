@@ -0,0 +1,112 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustMkPythonEnv(t *testing.T, root string, envs ...string) string {
+	t.Helper()
+	dir := filepath.Join(root, ".espressif", "python_env")
+	for _, env := range envs {
+		bin := filepath.Join(dir, env, "bin")
+		if err := os.MkdirAll(bin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(bin, "python"), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestHighestIDFEnvPicksNumericallyHighest(t *testing.T) {
+	root := t.TempDir()
+	pythonEnvDir := mustMkPythonEnv(t, root, "idf5.1_env", "idf5.10_env", "idf5.2_env")
+
+	got, err := highestIDFEnv(pythonEnvDir)
+	if err != nil {
+		t.Fatalf("highestIDFEnv: %v", err)
+	}
+	want := filepath.Join(pythonEnvDir, "idf5.10_env")
+	if got != want {
+		t.Errorf("highestIDFEnv() = %q, want %q (lexical sort would wrongly pick idf5.2_env)", got, want)
+	}
+}
+
+func TestHighestIDFEnvNoneFound(t *testing.T) {
+	root := t.TempDir()
+	got, err := highestIDFEnv(filepath.Join(root, ".espressif", "python_env"))
+	if err != nil {
+		t.Fatalf("highestIDFEnv: %v", err)
+	}
+	if got != "" {
+		t.Errorf("highestIDFEnv() = %q, want empty for missing dir", got)
+	}
+}
+
+func TestCompareIDFVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"idf5.1_env", "idf5.10_env", -1},
+		{"idf5.10_env", "idf5.1_env", 1},
+		{"idf5.2_env", "idf5.2_env", 0},
+		{"idf5.2.1_env", "idf5.2_env", 1},
+	}
+	for _, c := range cases {
+		got := compareIDFVersions(c.a, c.b)
+		if (got < 0 && c.want >= 0) || (got > 0 && c.want <= 0) || (got == 0 && c.want != 0) {
+			t.Errorf("compareIDFVersions(%q, %q) = %d, want sign %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestResolveArminoFromConfigFile(t *testing.T) {
+	workspace := t.TempDir()
+	arminoDir := filepath.Join(workspace, "sdk")
+	if err := os.MkdirAll(arminoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(workspace, ".embed-zig.toml")
+	if err := os.WriteFile(cfgPath, []byte(`[toolchain]
+armino_path = "`+arminoDir+`"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := Resolve(ToolchainSpec{Kind: Armino, WorkspaceRoot: workspace, ToolPrefix: "[test]"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if tc.Root != arminoDir {
+		t.Errorf("Root = %q, want %q", tc.Root, arminoDir)
+	}
+}
+
+func TestResolveArminoBazelFlagWinsOverConfig(t *testing.T) {
+	workspace := t.TempDir()
+	configured := filepath.Join(workspace, "from-config")
+	flagged := filepath.Join(workspace, "from-flag")
+	for _, d := range []string{configured, flagged} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfgPath := filepath.Join(workspace, ".embed-zig.toml")
+	if err := os.WriteFile(cfgPath, []byte(`[toolchain]
+armino_path = "`+configured+`"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := Resolve(ToolchainSpec{Kind: Armino, BazelFlag: flagged, WorkspaceRoot: workspace, ToolPrefix: "[test]"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if tc.Root != flagged {
+		t.Errorf("Root = %q, want %q (bazel flag should win)", tc.Root, flagged)
+	}
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BoardInfo is what esp_flash's chip-detection pass reads off the connected
+// board before flashing: its target, silicon revision, MAC address, and
+// flash size, plus the flash mode/freq write_flash should use.
+type BoardInfo struct {
+	Chip      string `json:"chip"`       // normalized ESP_CHIP-style target, e.g. "esp32s3"
+	Revision  string `json:"revision"`
+	MAC       string `json:"mac"`
+	FlashSize string `json:"flash_size"` // e.g. "4MB"
+	FlashMode string `json:"flash_mode"` // e.g. "dio"
+	FlashFreq string `json:"flash_freq"` // e.g. "80m"
+}
+
+var (
+	chipLineRe      = regexp.MustCompile(`Chip is ([A-Za-z0-9\-]+)(?:\s*\(revision (\S+)\))?`)
+	macLineRe       = regexp.MustCompile(`MAC: ([0-9a-fA-F:]+)`)
+	flashSizeLineRe = regexp.MustCompile(`Detected flash size: (\S+)`)
+)
+
+// defaultFlashParams gives the flash_mode/flash_freq esp-idf builds each
+// chip target with by default. esptool's chip-detect pass doesn't report the
+// mode/freq a part actually needs to be flashed with, so esp_flash falls
+// back to these once it knows which chip it's talking to.
+var defaultFlashParams = map[string]struct{ Mode, Freq string }{
+	"esp32":   {"dio", "40m"},
+	"esp32s2": {"dio", "40m"},
+	"esp32s3": {"dio", "80m"},
+	"esp32c3": {"dio", "80m"},
+	"esp32c2": {"dio", "60m"},
+	"esp32c6": {"dio", "80m"},
+	"esp32h2": {"dio", "48m"},
+}
+
+// ParseBoardInfo parses the stdout of `esptool.py --port <port> flash_id`
+// into a BoardInfo, filling in FlashMode/FlashFreq from defaultFlashParams.
+func ParseBoardInfo(output string) (BoardInfo, error) {
+	var info BoardInfo
+
+	m := chipLineRe.FindStringSubmatch(output)
+	if m == nil {
+		return info, fmt.Errorf("boardinfo: could not find chip type in esptool output")
+	}
+	info.Chip = NormalizeChipName(m[1])
+	info.Revision = m[2]
+
+	if m := macLineRe.FindStringSubmatch(output); m != nil {
+		info.MAC = m[1]
+	}
+	if m := flashSizeLineRe.FindStringSubmatch(output); m != nil {
+		info.FlashSize = m[1]
+	}
+
+	params, ok := defaultFlashParams[info.Chip]
+	if !ok {
+		return info, fmt.Errorf("boardinfo: unsupported chip %q", info.Chip)
+	}
+	info.FlashMode = params.Mode
+	info.FlashFreq = params.Freq
+
+	return info, nil
+}
+
+// NormalizeChipName converts esptool's display name ("ESP32-S3") to the
+// lowercase, no-hyphen form ESP_CHIP/ESP-IDF use ("esp32s3").
+func NormalizeChipName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "-", ""))
+}
+
+// CheckChipMatch returns an error describing the mismatch if expected (the
+// chip the binary was built for) doesn't match detected (the chip that
+// actually answered on the wire) — e.g. an esp32c3 image about to be written
+// to an esp32s3.
+func CheckChipMatch(expected, detected string) error {
+	if expected == "" || detected == "" {
+		return nil
+	}
+	if NormalizeChipName(expected) != NormalizeChipName(detected) {
+		return fmt.Errorf("chip mismatch: binary was built for %s but the connected board is %s", expected, detected)
+	}
+	return nil
+}
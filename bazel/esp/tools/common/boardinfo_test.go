@@ -0,0 +1,67 @@
+package common
+
+import "testing"
+
+const flashIDOutput = `esptool.py v4.7.0
+Serial port /dev/ttyUSB0
+Connecting....
+Detecting chip type... ESP32-S3
+Chip is ESP32-S3 (revision v0.1)
+Features: WiFi, BLE
+Crystal is 40MHz
+MAC: 7c:df:a1:00:11:22
+Uploading stub...
+Running stub...
+Stub running...
+Manufacturer: c8
+Device: 4016
+Detected flash size: 4MB
+Hard resetting via RTS pin...
+`
+
+func TestParseBoardInfo(t *testing.T) {
+	info, err := ParseBoardInfo(flashIDOutput)
+	if err != nil {
+		t.Fatalf("ParseBoardInfo: %v", err)
+	}
+	if info.Chip != "esp32s3" {
+		t.Errorf("Chip = %q, want esp32s3", info.Chip)
+	}
+	if info.Revision != "v0.1" {
+		t.Errorf("Revision = %q, want v0.1", info.Revision)
+	}
+	if info.MAC != "7c:df:a1:00:11:22" {
+		t.Errorf("MAC = %q, want 7c:df:a1:00:11:22", info.MAC)
+	}
+	if info.FlashSize != "4MB" {
+		t.Errorf("FlashSize = %q, want 4MB", info.FlashSize)
+	}
+	if info.FlashMode != "dio" || info.FlashFreq != "80m" {
+		t.Errorf("FlashMode/FlashFreq = %q/%q, want dio/80m", info.FlashMode, info.FlashFreq)
+	}
+}
+
+func TestParseBoardInfoMissingChipLine(t *testing.T) {
+	if _, err := ParseBoardInfo("nothing useful here\n"); err == nil {
+		t.Error("ParseBoardInfo(no chip line) = nil error, want error")
+	}
+}
+
+func TestParseBoardInfoUnsupportedChip(t *testing.T) {
+	_, err := ParseBoardInfo("Chip is ESP8266EX\nMAC: 00:00:00:00:00:00\n")
+	if err == nil {
+		t.Error("ParseBoardInfo(unsupported chip) = nil error, want error")
+	}
+}
+
+func TestCheckChipMatch(t *testing.T) {
+	if err := CheckChipMatch("esp32s3", "ESP32-S3"); err != nil {
+		t.Errorf("CheckChipMatch(matching) = %v, want nil", err)
+	}
+	if err := CheckChipMatch("esp32c3", "esp32s3"); err == nil {
+		t.Error("CheckChipMatch(mismatch) = nil error, want error")
+	}
+	if err := CheckChipMatch("", "esp32s3"); err != nil {
+		t.Errorf("CheckChipMatch(no expected) = %v, want nil", err)
+	}
+}
@@ -2,12 +2,19 @@ package common
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
+
+	"embed-zig/bazel/common/toolchain"
 )
 
 // SetupHome sets HOME if not already set.
@@ -26,104 +33,42 @@ func SetupHome() {
 	}
 }
 
-// SetupIDFEnv sets up ESP-IDF environment (PATH and IDF_PYTHON).
-// toolPrefix is used for log messages (e.g., "[esp_build]").
-func SetupIDFEnv(toolPrefix string) error {
-	home := os.Getenv("HOME")
-	pythonEnvDir := filepath.Join(home, ".espressif", "python_env")
-
-	var idfPythonEnv string
-	if _, err := os.Stat(pythonEnvDir); err == nil {
-		entries, err := os.ReadDir(pythonEnvDir)
-		if err == nil {
-			for _, entry := range entries {
-				if entry.IsDir() && strings.HasPrefix(entry.Name(), "idf") && strings.HasSuffix(entry.Name(), "_env") {
-					envPath := filepath.Join(pythonEnvDir, entry.Name())
-					pythonPath := filepath.Join(envPath, "bin", "python")
-					if _, err := os.Stat(pythonPath); err == nil {
-						idfPythonEnv = envPath
-						// Keep iterating to select the last match (highest version)
-					}
-				}
-			}
-		}
-	}
-
-	if idfPythonEnv == "" {
-		fmt.Printf("%s Warning: ESP-IDF Python env not found\n", toolPrefix)
-		// Try to use system python3 and hope export.sh was sourced
-		os.Setenv("IDF_PYTHON", "python3")
-		return nil
-	}
-
-	fmt.Printf("[esp] Using Python env: %s\n", idfPythonEnv)
-
-	// Build PATH with ESP-IDF tools
-	espressifTools := filepath.Join(home, ".espressif", "tools")
-	var idfToolsPaths []string
-
-	if _, err := os.Stat(espressifTools); err == nil {
-		// Find all bin directories under tools
-		filepath.Walk(espressifTools, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
-			}
-			if info.IsDir() && info.Name() == "bin" {
-				// Limit depth to 4 levels (same as shell script)
-				rel, _ := filepath.Rel(espressifTools, path)
-				if strings.Count(rel, string(os.PathSeparator)) <= 3 {
-					idfToolsPaths = append(idfToolsPaths, path)
-				}
-			}
-			return nil
-		})
+// SetupIDFEnv resolves the ESP-IDF toolchain via toolchain.Resolve (picking
+// the highest-versioned idfX.Y_env by semver, not lexically), applies its
+// PATH to the current process, and sets IDF_PYTHON. idfPathFlag is the
+// --//bazel:idf_path value, if the caller has one ("" falls through to
+// .embed-zig.toml / IDF_PATH / ~/.espressif).
+func SetupIDFEnv(idfPathFlag, toolPrefix string) error {
+	tc, err := toolchain.Resolve(toolchain.ToolchainSpec{
+		Kind:          toolchain.ESPIDF,
+		BazelFlag:     idfPathFlag,
+		WorkspaceRoot: os.Getenv("BUILD_WORKSPACE_DIRECTORY"),
+		ToolPrefix:    toolPrefix,
+		WorkDir:       os.Getenv("ESP_WORK_DIR"),
+	})
+	if err != nil {
+		return err
 	}
 
-	idfPath := os.Getenv("IDF_PATH")
-	pathComponents := []string{
-		filepath.Join(idfPythonEnv, "bin"),
+	if len(tc.BinPaths()) > 0 {
+		os.Setenv("PATH", strings.Join(tc.BinPaths(), string(os.PathListSeparator))+string(os.PathListSeparator)+os.Getenv("PATH"))
 	}
-	pathComponents = append(pathComponents, idfToolsPaths...)
-	if idfPath != "" {
-		pathComponents = append(pathComponents, filepath.Join(idfPath, "tools"))
-	}
-	pathComponents = append(pathComponents, os.Getenv("PATH"))
-
-	newPath := strings.Join(pathComponents, string(os.PathListSeparator))
-	os.Setenv("PATH", newPath)
-	os.Setenv("IDF_PYTHON", filepath.Join(idfPythonEnv, "bin", "python"))
-
+	os.Setenv("IDF_PYTHON", tc.Python())
 	return nil
 }
 
 // FindIDFPython finds the ESP-IDF Python interpreter.
 // toolPrefix is used for log messages (e.g., "[esp_flash]").
 func FindIDFPython(toolPrefix string) (string, error) {
-	home := os.Getenv("HOME")
-	pythonEnvDir := filepath.Join(home, ".espressif", "python_env")
-
-	var lastPythonPath string
-	if _, err := os.Stat(pythonEnvDir); err == nil {
-		entries, err := os.ReadDir(pythonEnvDir)
-		if err == nil {
-			for _, entry := range entries {
-				if entry.IsDir() && strings.HasPrefix(entry.Name(), "idf") && strings.HasSuffix(entry.Name(), "_env") {
-					pythonPath := filepath.Join(pythonEnvDir, entry.Name(), "bin", "python")
-					if _, err := os.Stat(pythonPath); err == nil {
-						lastPythonPath = pythonPath
-						// Keep iterating to select the last match (highest version)
-					}
-				}
-			}
-		}
-	}
-
-	if lastPythonPath != "" {
-		return lastPythonPath, nil
+	tc, err := toolchain.Resolve(toolchain.ToolchainSpec{
+		Kind:          toolchain.ESPIDF,
+		WorkspaceRoot: os.Getenv("BUILD_WORKSPACE_DIRECTORY"),
+		ToolPrefix:    toolPrefix,
+	})
+	if err != nil {
+		return "", err
 	}
-
-	fmt.Printf("%s Warning: ESP-IDF Python env not found, using system python3\n", toolPrefix)
-	return "python3", nil
+	return tc.Python(), nil
 }
 
 // DetectSerialPort auto-detects or validates the serial port.
@@ -182,10 +127,92 @@ func KillPortProcess(port string, toolPrefix string) {
 
 // RunCommand executes a command with stdout/stderr redirected.
 func RunCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	_, err := RunCommandCtx(context.Background(), RunOptions{Name: name, Args: args})
+	return err
+}
+
+// RunOptions configures RunCommandCtx.
+type RunOptions struct {
+	Name string
+	Args []string
+	Dir  string
+	Env  []string // nil inherits the current process environment
+	Stdin io.Reader
+
+	// Timeout bounds the whole invocation; zero means no timeout beyond ctx.
+	Timeout time.Duration
+
+	// TeeStdout/TeeStderr additionally receive a copy of the command's
+	// output, on top of the os.Stdout/os.Stderr passthrough.
+	TeeStdout io.Writer
+	TeeStderr io.Writer
+
+	// KillGracePeriod, if set, sends SIGINT on cancellation and escalates to
+	// SIGKILL only after this long — important since an interrupted idf.py
+	// build can leave dangling ninja processes behind a bare kill.
+	KillGracePeriod time.Duration
+}
+
+// RunResult captures the outcome of a RunCommandCtx invocation.
+type RunResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+}
+
+// RunCommandCtx runs opts.Name/opts.Args under ctx, honoring cancellation,
+// opts.Timeout, and opts.KillGracePeriod, and logs a structured one-liner
+// (cmd, dir, duration_ms, exit) for post-mortem. Output is streamed to
+// os.Stdout/os.Stderr as before, tee'd to opts.TeeStdout/TeeStderr if set, and
+// also captured into the returned RunResult.
+func RunCommandCtx(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, opts.Name, opts.Args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.Stdin = opts.Stdin
+
+	var outBuf, errBuf bytes.Buffer
+	stdoutWriters := []io.Writer{&outBuf, os.Stdout}
+	stderrWriters := []io.Writer{&errBuf, os.Stderr}
+	if opts.TeeStdout != nil {
+		stdoutWriters = append(stdoutWriters, opts.TeeStdout)
+	}
+	if opts.TeeStderr != nil {
+		stderrWriters = append(stderrWriters, opts.TeeStderr)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+
+	if opts.KillGracePeriod > 0 {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+		cmd.WaitDelay = opts.KillGracePeriod
+	}
+
+	start := time.Now()
+	runErr := cmd.Run()
+	duration := time.Since(start)
+
+	exitCode := 0
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	} else if runErr != nil {
+		exitCode = -1
+	}
+
+	log.Printf("cmd=%q dir=%q duration_ms=%d exit=%d",
+		strings.TrimSpace(opts.Name+" "+strings.Join(opts.Args, " ")), opts.Dir, duration.Milliseconds(), exitCode)
+
+	result := &RunResult{ExitCode: exitCode, Stdout: outBuf.Bytes(), Stderr: errBuf.Bytes(), Duration: duration}
+	return result, runErr
 }
 
 // CopyFile copies a file from src to dst.
@@ -200,8 +227,35 @@ func CopyFile(src, dst string) error {
 	return os.WriteFile(dst, data, 0644)
 }
 
-// ExtractChipFromSdkconfig extracts chip target from sdkconfig.defaults.
-func ExtractChipFromSdkconfig(path string) (string, error) {
+// ExtractChipFromSdkconfig extracts the CONFIG_IDF_TARGET chip target from
+// sdkconfig.defaults at path. If board is non-empty and a
+// "<path>.<board>" overlay file exists alongside it (e.g.
+// sdkconfig.defaults.devkit-c), the overlay is read too and its own
+// CONFIG_IDF_TARGET, if set, wins — the two files are merged in declaration
+// order, base then overlay, so a board-specific chip override always takes
+// precedence over the shared default.
+func ExtractChipFromSdkconfig(path, board string) (string, error) {
+	chip, err := extractChipFromSdkconfigFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if board == "" {
+		return chip, nil
+	}
+	overlay := path + "." + board
+	if _, err := os.Stat(overlay); err != nil {
+		return chip, nil
+	}
+	if overlayChip, err := extractChipFromSdkconfigFile(overlay); err == nil {
+		chip = overlayChip
+	}
+	return chip, nil
+}
+
+// extractChipFromSdkconfigFile scans a single sdkconfig-style file for
+// CONFIG_IDF_TARGET.
+func extractChipFromSdkconfigFile(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return "", fmt.Errorf("failed to open %s: %w", path, err)
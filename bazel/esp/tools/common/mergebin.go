@@ -0,0 +1,174 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FlashSizeBytes maps a --flash-size value (e.g. "4MB") to its size in bytes.
+func FlashSizeBytes(size string) (int64, error) {
+	switch size {
+	case "2MB":
+		return 2 * 1024 * 1024, nil
+	case "4MB":
+		return 4 * 1024 * 1024, nil
+	case "8MB":
+		return 8 * 1024 * 1024, nil
+	case "16MB":
+		return 16 * 1024 * 1024, nil
+	default:
+		return 0, fmt.Errorf("mergebin: unsupported flash size %q (want 2MB, 4MB, 8MB, or 16MB)", size)
+	}
+}
+
+// Region is one offset-addressed blob to place in a merged flash image, e.g.
+// the bootloader at 0x0 or the app at 0x10000.
+type Region struct {
+	Offset int64
+	Data   []byte
+}
+
+// MergeRegions lays regions out into a single flashSize-byte image, filling
+// every byte not covered by a region with 0xFF to match raw NOR flash's
+// erased state — the same convention esptool.py merge_bin uses.
+func MergeRegions(regions []Region, flashSize int64) ([]byte, error) {
+	sorted := append([]Region(nil), regions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	img := bytes.Repeat([]byte{0xFF}, int(flashSize))
+	var prevEnd int64
+	for _, r := range sorted {
+		if len(r.Data) == 0 {
+			continue
+		}
+		end := r.Offset + int64(len(r.Data))
+		if r.Offset < 0 || end > flashSize {
+			return nil, fmt.Errorf("mergebin: region at 0x%x (len %d) overflows %d-byte flash", r.Offset, len(r.Data), flashSize)
+		}
+		if r.Offset < prevEnd {
+			return nil, fmt.Errorf("mergebin: region at 0x%x overlaps preceding region ending at 0x%x", r.Offset, prevEnd)
+		}
+		copy(img[r.Offset:end], r.Data)
+		prevEnd = end
+	}
+	return img, nil
+}
+
+// WriteIntelHex encodes data (read from flash offset base) as Intel HEX,
+// emitting an Extended Linear Address record whenever the upper 16 bits of
+// the address change (including the first record, if base itself starts
+// above the 16-bit range).
+func WriteIntelHex(w io.Writer, data []byte, base int64) error {
+	const recLen = 32
+	var curUpper uint16
+	first := true
+	for off := 0; off < len(data); off += recLen {
+		end := off + recLen
+		if end > len(data) {
+			end = len(data)
+		}
+		addr := base + int64(off)
+		upper := uint16(addr >> 16)
+		if (first && upper != 0) || (!first && upper != curUpper) {
+			if err := writeHexRecord(w, 0, 0x04, []byte{byte(upper >> 8), byte(upper)}); err != nil {
+				return err
+			}
+			curUpper = upper
+		}
+		first = false
+		if err := writeHexRecord(w, uint16(addr&0xFFFF), 0x00, data[off:end]); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, ":00000001FF\n")
+	return err
+}
+
+// writeHexRecord writes one Intel HEX record line (":LLAAAATT<data>CC").
+func writeHexRecord(w io.Writer, addr uint16, recType byte, payload []byte) error {
+	buf := make([]byte, 0, 4+len(payload))
+	buf = append(buf, byte(len(payload)), byte(addr>>8), byte(addr), recType)
+	buf = append(buf, payload...)
+
+	var sum int
+	for _, b := range buf {
+		sum += int(b)
+	}
+	checksum := byte((0x100 - sum) & 0xFF)
+
+	_, err := fmt.Fprintf(w, ":%s%02X\n", strings.ToUpper(hex.EncodeToString(buf)), checksum)
+	return err
+}
+
+// uf2FamilyIDs maps ESP-IDF chip targets (as returned by
+// ExtractChipFromSdkconfig) to their Microsoft UF2 family ID, as registered
+// in https://github.com/microsoft/uf2/blob/master/utils/uf2families.json.
+var uf2FamilyIDs = map[string]uint32{
+	"esp32":   0x1c5f21b0,
+	"esp32s2": 0xbfdd4eee,
+	"esp32s3": 0xc47e5767,
+	"esp32c3": 0xd42ba06c,
+	"esp32c2": 0x2b88d29c,
+	"esp32c6": 0x540ddf62,
+	"esp32h2": 0x332726f6,
+}
+
+// UF2FamilyID looks up the Microsoft UF2 family ID for an ESP32 chip target.
+func UF2FamilyID(chip string) (uint32, error) {
+	id, ok := uf2FamilyIDs[strings.ToLower(chip)]
+	if !ok {
+		return 0, fmt.Errorf("mergebin: no UF2 family ID known for chip %q", chip)
+	}
+	return id, nil
+}
+
+const (
+	uf2Magic0              = 0x0A324655
+	uf2Magic1              = 0x9E5D5157
+	uf2MagicEnd            = 0x0AB16F30
+	uf2FlagFamilyIDPresent = 0x00002000
+	uf2BlockSize           = 512
+	uf2PayloadSize         = 256
+)
+
+// WriteUF2 encodes data (read from flash offset base) as a Microsoft UF2
+// image tagged with familyID, in fixed 256-byte payload blocks.
+func WriteUF2(w io.Writer, data []byte, base uint32, familyID uint32) error {
+	numBlocks := (len(data) + uf2PayloadSize - 1) / uf2PayloadSize
+	if numBlocks == 0 {
+		numBlocks = 1
+	}
+
+	block := make([]byte, uf2BlockSize)
+	for i := 0; i < numBlocks; i++ {
+		start := i * uf2PayloadSize
+		end := start + uf2PayloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		for j := range block {
+			block[j] = 0
+		}
+		binary.LittleEndian.PutUint32(block[0:4], uf2Magic0)
+		binary.LittleEndian.PutUint32(block[4:8], uf2Magic1)
+		binary.LittleEndian.PutUint32(block[8:12], uf2FlagFamilyIDPresent)
+		binary.LittleEndian.PutUint32(block[12:16], base+uint32(start))
+		binary.LittleEndian.PutUint32(block[16:20], uint32(end-start))
+		binary.LittleEndian.PutUint32(block[20:24], uint32(i))
+		binary.LittleEndian.PutUint32(block[24:28], uint32(numBlocks))
+		binary.LittleEndian.PutUint32(block[28:32], familyID)
+		copy(block[32:32+uf2PayloadSize], data[start:end])
+		binary.LittleEndian.PutUint32(block[uf2BlockSize-4:], uf2MagicEnd)
+
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMergeRegionsLayout(t *testing.T) {
+	bootloader := bytes.Repeat([]byte{0xAA}, 4)
+	partTable := bytes.Repeat([]byte{0xBB}, 4)
+	app := bytes.Repeat([]byte{0xCC}, 4)
+
+	img, err := MergeRegions([]Region{
+		{Offset: 0x10, Data: app},
+		{Offset: 0x0, Data: bootloader},
+		{Offset: 0x8, Data: partTable},
+	}, 0x20)
+	if err != nil {
+		t.Fatalf("MergeRegions: %v", err)
+	}
+
+	want := append([]byte{}, bootloader...)
+	want = append(want, bytes.Repeat([]byte{0xFF}, 4)...)
+	want = append(want, partTable...)
+	want = append(want, bytes.Repeat([]byte{0xFF}, 4)...)
+	want = append(want, app...)
+	want = append(want, bytes.Repeat([]byte{0xFF}, 12)...)
+
+	if !bytes.Equal(img, want) {
+		t.Errorf("MergeRegions image =\n%x\nwant\n%x", img, want)
+	}
+}
+
+func TestMergeRegionsRejectsOverflow(t *testing.T) {
+	_, err := MergeRegions([]Region{{Offset: 0x10, Data: []byte{1, 2, 3}}}, 0x10)
+	if err == nil {
+		t.Error("MergeRegions(overflowing region) = nil error, want error")
+	}
+}
+
+func TestMergeRegionsRejectsOverlap(t *testing.T) {
+	_, err := MergeRegions([]Region{
+		{Offset: 0x0, Data: []byte{1, 2, 3, 4}},
+		{Offset: 0x2, Data: []byte{5, 6}},
+	}, 0x10)
+	if err == nil {
+		t.Error("MergeRegions(overlapping regions) = nil error, want error")
+	}
+}
+
+func TestWriteIntelHexRoundTripsKnownBytes(t *testing.T) {
+	var buf bytes.Buffer
+	data := []byte{0x01, 0x02, 0x03, 0x04}
+	if err := WriteIntelHex(&buf, data, 0x100); err != nil {
+		t.Fatalf("WriteIntelHex: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, ":0401000001020304") {
+		t.Errorf("WriteIntelHex output = %q, want data record prefix :0401000001020304...", out)
+	}
+	if !strings.HasSuffix(out, ":00000001FF\n") {
+		t.Errorf("WriteIntelHex output = %q, want EOF record suffix", out)
+	}
+}
+
+func TestWriteIntelHexEmitsExtendedLinearAddress(t *testing.T) {
+	var buf bytes.Buffer
+	data := make([]byte, 32)
+	if err := WriteIntelHex(&buf, data, 0x10000); err != nil {
+		t.Fatalf("WriteIntelHex: %v", err)
+	}
+	if !strings.Contains(buf.String(), ":020000040001F9\n") {
+		t.Errorf("WriteIntelHex output = %q, want an Extended Linear Address record for upper word 0x0001", buf.String())
+	}
+}
+
+func TestUF2FamilyIDUnknownChip(t *testing.T) {
+	if _, err := UF2FamilyID("esp8266"); err == nil {
+		t.Error("UF2FamilyID(unknown chip) = nil error, want error")
+	}
+}
+
+func TestWriteUF2BlockLayout(t *testing.T) {
+	familyID, err := UF2FamilyID("esp32")
+	if err != nil {
+		t.Fatalf("UF2FamilyID: %v", err)
+	}
+
+	data := bytes.Repeat([]byte{0x42}, uf2PayloadSize+10)
+	var buf bytes.Buffer
+	if err := WriteUF2(&buf, data, 0x1000, familyID); err != nil {
+		t.Fatalf("WriteUF2: %v", err)
+	}
+
+	if got, want := buf.Len(), 2*uf2BlockSize; got != want {
+		t.Fatalf("WriteUF2 output length = %d, want %d (2 blocks)", got, want)
+	}
+
+	block0 := buf.Bytes()[:uf2BlockSize]
+	if magic := le32(block0[0:4]); magic != uf2Magic0 {
+		t.Errorf("block0 magic0 = %#x, want %#x", magic, uf2Magic0)
+	}
+	if numBlocks := le32(block0[24:28]); numBlocks != 2 {
+		t.Errorf("block0 numBlocks = %d, want 2", numBlocks)
+	}
+	if gotFamily := le32(block0[28:32]); gotFamily != familyID {
+		t.Errorf("block0 familyID = %#x, want %#x", gotFamily, familyID)
+	}
+	if payload := block0[32 : 32+uf2PayloadSize]; !bytes.Equal(payload, data[:uf2PayloadSize]) {
+		t.Errorf("block0 payload = %x, want %x", payload, data[:uf2PayloadSize])
+	}
+	if magicEnd := le32(block0[uf2BlockSize-4:]); magicEnd != uf2MagicEnd {
+		t.Errorf("block0 magicEnd = %#x, want %#x", magicEnd, uf2MagicEnd)
+	}
+
+	block1 := buf.Bytes()[uf2BlockSize:]
+	if payloadSize := le32(block1[16:20]); payloadSize != 10 {
+		t.Errorf("block1 payload size = %d, want 10 (tail of data)", payloadSize)
+	}
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
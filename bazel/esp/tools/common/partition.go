@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PartitionEntry is one row of an ESP-IDF partition table, whether parsed
+// from the compiled binary or its CSV source.
+type PartitionEntry struct {
+	Type    byte
+	SubType byte
+	Offset  int64
+	Size    int64
+	Label   string
+}
+
+const (
+	partitionEntrySize = 32
+	partitionMagic     = 0x50AA
+
+	// PartitionTypeApp and PartitionTypeData mirror ESP-IDF's
+	// esp_partition_type_t values.
+	PartitionTypeApp  = 0x00
+	PartitionTypeData = 0x01
+)
+
+// ParsePartitionTable parses a compiled ESP-IDF partition table binary (the
+// bytes esp_flash writes to 0x8000), reading fixed 32-byte entries until the
+// magic number stops matching.
+func ParsePartitionTable(data []byte) ([]PartitionEntry, error) {
+	var entries []PartitionEntry
+	for off := 0; off+partitionEntrySize <= len(data); off += partitionEntrySize {
+		row := data[off : off+partitionEntrySize]
+		if binary.LittleEndian.Uint16(row[0:2]) != partitionMagic {
+			break
+		}
+		entries = append(entries, PartitionEntry{
+			Type:    row[2],
+			SubType: row[3],
+			Offset:  int64(binary.LittleEndian.Uint32(row[4:8])),
+			Size:    int64(binary.LittleEndian.Uint32(row[8:12])),
+			Label:   strings.TrimRight(string(row[12:28]), "\x00"),
+		})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("partition table: no entries found (bad magic or empty table)")
+	}
+	return entries, nil
+}
+
+// ParsePartitionCSV parses an ESP-IDF partition table CSV, the human-authored
+// input to gen_esp32part.py, e.g.:
+//
+//	# Name,   Type, SubType,  Offset,   Size
+//	nvs,      data, nvs,      0x9000,   0x6000
+//	factory,  app,  factory,  0x10000,  1M
+func ParsePartitionCSV(data []byte) ([]PartitionEntry, error) {
+	var entries []PartitionEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(fields) < 5 {
+			continue
+		}
+
+		typ, err := csvPartitionType(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := parseCSVSize(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("partition table: bad offset %q: %w", fields[3], err)
+		}
+		size, err := parseCSVSize(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("partition table: bad size %q: %w", fields[4], err)
+		}
+		entries = append(entries, PartitionEntry{Type: typ, Offset: offset, Size: size, Label: fields[0]})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("partition table: no entries found in CSV")
+	}
+	return entries, nil
+}
+
+func csvPartitionType(s string) (byte, error) {
+	switch s {
+	case "app":
+		return PartitionTypeApp, nil
+	case "data":
+		return PartitionTypeData, nil
+	default:
+		return 0, fmt.Errorf("partition table: unknown type %q", s)
+	}
+}
+
+// parseCSVSize parses a partition-table size/offset field, which may be a
+// hex literal (0x10000), a bare decimal, or carry a K/M suffix (1M, 256K).
+func parseCSVSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		mult = 1024
+		s = s[:len(s)-1]
+	case 'M', 'm':
+		mult = 1024 * 1024
+		s = s[:len(s)-1]
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		v, err := strconv.ParseInt(s[2:], 16, 64)
+		return v * mult, err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	return v * mult, err
+}
+
+// ParsePartitionFile reads and parses a partition table file, dispatching on
+// extension: .csv is parsed as the gen_esp32part.py source format, anything
+// else as the compiled binary esp_flash actually writes to the chip.
+func ParsePartitionFile(path string) ([]PartitionEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".csv") {
+		return ParsePartitionCSV(data)
+	}
+	return ParsePartitionTable(data)
+}
+
+// AppOffset returns the flash offset of the first app partition (factory or
+// an OTA slot), which is where esp_flash writes the application binary.
+func AppOffset(entries []PartitionEntry) (int64, error) {
+	for _, e := range entries {
+		if e.Type == PartitionTypeApp {
+			return e.Offset, nil
+		}
+	}
+	return 0, fmt.Errorf("partition table: no app partition found")
+}
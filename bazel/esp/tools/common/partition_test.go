@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func encodePartitionEntry(typ, subType byte, offset, size uint32, label string) []byte {
+	row := make([]byte, partitionEntrySize)
+	binary.LittleEndian.PutUint16(row[0:2], partitionMagic)
+	row[2] = typ
+	row[3] = subType
+	binary.LittleEndian.PutUint32(row[4:8], offset)
+	binary.LittleEndian.PutUint32(row[8:12], size)
+	copy(row[12:28], label)
+	return row
+}
+
+func TestParsePartitionTable(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(encodePartitionEntry(PartitionTypeData, 0x02, 0x9000, 0x6000, "nvs"))
+	buf.Write(encodePartitionEntry(PartitionTypeApp, 0x00, 0x10000, 0x100000, "factory"))
+
+	entries, err := ParsePartitionTable(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParsePartitionTable: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	if entries[1].Label != "factory" || entries[1].Offset != 0x10000 {
+		t.Errorf("entries[1] = %+v, want app partition at 0x10000", entries[1])
+	}
+}
+
+func TestParsePartitionTableRejectsEmpty(t *testing.T) {
+	if _, err := ParsePartitionTable(make([]byte, 32)); err == nil {
+		t.Error("ParsePartitionTable(bad magic) = nil error, want error")
+	}
+}
+
+func TestParsePartitionCSV(t *testing.T) {
+	csv := []byte(`# Name,   Type, SubType,  Offset,   Size
+nvs,      data, nvs,      0x9000,   0x6000
+phy_init, data, phy,      0xf000,   0x1000
+factory,  app,  factory,  0x10000,  1M
+`)
+	entries, err := ParsePartitionCSV(csv)
+	if err != nil {
+		t.Fatalf("ParsePartitionCSV: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(entries))
+	}
+	app := entries[2]
+	if app.Type != PartitionTypeApp || app.Offset != 0x10000 || app.Size != 1024*1024 {
+		t.Errorf("app entry = %+v, want {Type:app Offset:0x10000 Size:1MB}", app)
+	}
+}
+
+func TestAppOffset(t *testing.T) {
+	entries := []PartitionEntry{
+		{Type: PartitionTypeData, Offset: 0x9000},
+		{Type: PartitionTypeApp, Offset: 0x20000},
+	}
+	off, err := AppOffset(entries)
+	if err != nil {
+		t.Fatalf("AppOffset: %v", err)
+	}
+	if off != 0x20000 {
+		t.Errorf("AppOffset = 0x%x, want 0x20000", off)
+	}
+}
+
+func TestAppOffsetNoAppPartition(t *testing.T) {
+	entries := []PartitionEntry{{Type: PartitionTypeData, Offset: 0x9000}}
+	if _, err := AppOffset(entries); err == nil {
+		t.Error("AppOffset(no app partition) = nil error, want error")
+	}
+}
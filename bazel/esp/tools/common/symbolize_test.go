@@ -0,0 +1,106 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBacktracePCs(t *testing.T) {
+	line := "Backtrace: 0x400d1234:0x3ffb0010 0x400d5678:0x3ffb0030"
+	got := backtracePCs(line)
+	want := []string{"0x400d1234", "0x400d5678"}
+	if len(got) != len(want) {
+		t.Fatalf("backtracePCs(%q) = %v, want %v", line, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("backtracePCs(%q)[%d] = %q, want %q", line, i, got[i], want[i])
+		}
+	}
+}
+
+func TestBacktracePCsIgnoresNonBacktraceLine(t *testing.T) {
+	if got := backtracePCs("I (1234) app: hello world"); got != nil {
+		t.Errorf("backtracePCs(non-backtrace) = %v, want nil", got)
+	}
+}
+
+// fakeAddr2Line writes an executable script standing in for addr2line: given
+// -p -f -C -e <elf> <pc...>, it prints one "func at file.c:N" line per pc.
+func fakeAddr2Line(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake addr2line script requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "xtensa-esp32-elf-addr2line")
+	script := "#!/bin/sh\nshift 5\nfor pc in \"$@\"; do echo \"func_$pc at main.c:1\"; done\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("write fake addr2line: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return path
+}
+
+func TestSymbolizerWrapDecodesBacktrace(t *testing.T) {
+	fakeAddr2Line(t)
+
+	elf := filepath.Join(t.TempDir(), "app.elf")
+	if err := os.WriteFile(elf, []byte("not a real elf"), 0644); err != nil {
+		t.Fatalf("write fake elf: %v", err)
+	}
+
+	sym, err := NewSymbolizer(elf)
+	if err != nil {
+		t.Fatalf("NewSymbolizer: %v", err)
+	}
+
+	var out bytes.Buffer
+	w := sym.Wrap(&out)
+	fmt.Fprintln(w, "Guru Meditation Error: Core 0 panic'ed (LoadProhibited)")
+	fmt.Fprintln(w, "Backtrace: 0x400d1234:0x3ffb0010 0x400d5678:0x3ffb0030")
+
+	got := out.String()
+	if !strings.Contains(got, ansiRed+"Guru Meditation Error") {
+		t.Errorf("output missing colorized Guru Meditation header: %q", got)
+	}
+	if !strings.Contains(got, "func_0x400d1234 at main.c:1") {
+		t.Errorf("output missing decoded frame 0: %q", got)
+	}
+	if !strings.Contains(got, "func_0x400d5678 at main.c:1") {
+		t.Errorf("output missing decoded frame 1: %q", got)
+	}
+}
+
+func TestSymbolizerWrapDecodesAbortPC(t *testing.T) {
+	fakeAddr2Line(t)
+
+	elf := filepath.Join(t.TempDir(), "app.elf")
+	if err := os.WriteFile(elf, []byte("not a real elf"), 0644); err != nil {
+		t.Fatalf("write fake elf: %v", err)
+	}
+
+	sym, err := NewSymbolizer(elf)
+	if err != nil {
+		t.Fatalf("NewSymbolizer: %v", err)
+	}
+
+	var out bytes.Buffer
+	w := sym.Wrap(&out)
+	fmt.Fprintln(w, "abort() was called at PC 0x400d9abc on core 0")
+
+	if got := out.String(); !strings.Contains(got, "func_0x400d9abc at main.c:1") {
+		t.Errorf("output missing decoded abort PC: %q", got)
+	}
+}
+
+func TestNewSymbolizerMissingELF(t *testing.T) {
+	if _, err := NewSymbolizer(filepath.Join(t.TempDir(), "does-not-exist.elf")); err == nil {
+		t.Error("NewSymbolizer(missing ELF) = nil error, want error")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheKey derives a content hash identifying one esp_configure run: the
+// sdkconfig.defaults contents, resolved IDF version, chip, REQUIRES list,
+// and idf_component.yml -- any change to one of these can change the
+// generated config tree or include paths, so any change invalidates the
+// cache.
+func cacheKey(sdkconfigPath, idfVersion, chip, requires, idfComponentYml string) (string, error) {
+	data, err := os.ReadFile(sdkconfigPath)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	fmt.Fprintf(h, "\x00idf=%s\x00chip=%s\x00requires=%s\x00yml=%s", idfVersion, chip, requires, idfComponentYml)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idfVersion best-effort reads $IDF_PATH/version.txt (e.g. "v5.2.1"), the
+// same file idf.py itself reads for `idf.py --version`. "unknown" just
+// means the cache key tracks IDF upgrades less precisely, not a fatal
+// error.
+func idfVersion() string {
+	idfPath := os.Getenv("IDF_PATH")
+	if idfPath == "" {
+		return "unknown"
+	}
+	data, err := os.ReadFile(filepath.Join(idfPath, "version.txt"))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// cacheEntryDir returns the on-disk directory a given cache key's config
+// tree lives under, $HOME/.cache/esp_configure/<key>.
+func cacheEntryDir(key string) (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("HOME not set")
+	}
+	return filepath.Join(home, ".cache", "esp_configure", key), nil
+}
+
+// loadCache reloads a previously cached config tree for key into configDir,
+// includeDirsFile, and (if workspaceRoot is set) workspaceRoot's
+// compile_commands.json, returning true on a cache hit. A missing or
+// partial cache entry is treated as a miss, not an error -- the caller
+// just regenerates it via the normal idf.py path.
+func loadCache(key, configDir, includeDirsFile, workspaceRoot string) bool {
+	dir, err := cacheEntryDir(key)
+	if err != nil {
+		return false
+	}
+	cachedConfig := filepath.Join(dir, "config")
+	cachedIncludes := filepath.Join(dir, "include_dirs.txt")
+	if _, err := os.Stat(cachedConfig); err != nil {
+		return false
+	}
+	if _, err := os.Stat(cachedIncludes); err != nil {
+		return false
+	}
+	if err := copyDir(cachedConfig, configDir); err != nil {
+		return false
+	}
+	if err := copyFile(cachedIncludes, includeDirsFile); err != nil {
+		return false
+	}
+	if workspaceRoot != "" {
+		cachedCC := filepath.Join(dir, "compile_commands.json")
+		if _, err := os.Stat(cachedCC); err == nil {
+			_ = copyFile(cachedCC, filepath.Join(workspaceRoot, "compile_commands.json"))
+		}
+	}
+	return true
+}
+
+// saveCache persists the config tree configure() just generated under key,
+// so a later run with an identical cacheKey can skip idf.py set-target/
+// reconfigure entirely. Failure to cache isn't fatal -- configure() already
+// produced working output for this run; caching only helps the next one.
+func saveCache(key, configDir, includeDirsFile, buildDir, workspaceRoot string) error {
+	dir, err := cacheEntryDir(key)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := copyDir(configDir, filepath.Join(dir, "config")); err != nil {
+		return err
+	}
+	if err := copyFile(includeDirsFile, filepath.Join(dir, "include_dirs.txt")); err != nil {
+		return err
+	}
+	ccSrc := filepath.Join(buildDir, "compile_commands.json")
+	if _, err := os.Stat(ccSrc); err == nil {
+		if err := copyFile(ccSrc, filepath.Join(dir, "compile_commands.json")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// cmakeFileAPIClient is the query directory name under .cmake/api/v1/query/
+// this tool uses. esp_configure is the only client of its own temp build
+// tree, so a fixed name is fine.
+const cmakeFileAPIClient = "client-esp-configure"
+
+// writeCMakeFileAPIQuery drops a stateless codemodel-v2 query file into
+// buildDir so that idf.py's underlying cmake configure writes a codemodel
+// reply under buildDir/.cmake/api/v1/reply/ (the CMake file-based API,
+// https://cmake.org/cmake/help/latest/manual/cmake-file-api.7.html). This
+// must be written before the FIRST cmake configure of buildDir -- idf.py
+// set-target's initial configure, not just idf.py reconfigure's -- or
+// there's no reply for extractIncludeDirs to parse later.
+func writeCMakeFileAPIQuery(buildDir string) error {
+	queryDir := filepath.Join(buildDir, ".cmake", "api", "v1", "query", cmakeFileAPIClient)
+	if err := os.MkdirAll(queryDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(queryDir, "codemodel-v2"), nil, 0644)
+}
+
+// fileAPIIndex is the subset of a CMake file API reply index file
+// (.cmake/api/v1/reply/index-*.json) this tool needs.
+type fileAPIIndex struct {
+	Objects []struct {
+		Kind     string `json:"kind"`
+		JsonFile string `json:"jsonFile"`
+	} `json:"objects"`
+}
+
+// codemodelReply is the subset of a codemodel-v2 reply object this tool
+// needs: the build's targets, one reply JSON file per target.
+type codemodelReply struct {
+	Configurations []struct {
+		Targets []struct {
+			Name     string `json:"name"`
+			JsonFile string `json:"jsonFile"`
+		} `json:"targets"`
+	} `json:"configurations"`
+}
+
+// targetReply is the subset of a per-target reply object this tool needs:
+// each compile group's include path list.
+type targetReply struct {
+	CompileGroups []struct {
+		Includes []struct {
+			Path string `json:"path"`
+		} `json:"includes"`
+	} `json:"compileGroups"`
+}
+
+// extractIncludeDirs derives the include path list for the firmware build
+// from the CMake file API reply under buildDir/.cmake/api/v1/reply/
+// (written by the configure idf.py just ran, in response to the query
+// writeCMakeFileAPIQuery left behind), unioning compileGroup.includes[]
+// across every target -- not just the main component -- so transitive
+// component includes that the old `cmake -L` cache-variable grep missed
+// are captured too. The deduped, sorted result, plus configDir (for the
+// generated sdkconfig.h), is written one path per line to outputFile.
+func extractIncludeDirs(buildDir, outputFile, configDir string) error {
+	replyDir := filepath.Join(buildDir, ".cmake", "api", "v1", "reply")
+	indexPath, err := latestIndexFile(replyDir)
+	if err != nil {
+		return err
+	}
+
+	var index fileAPIIndex
+	if err := readJSONFile(indexPath, &index); err != nil {
+		return fmt.Errorf("parse %s: %w", indexPath, err)
+	}
+
+	var codemodelPath string
+	for _, obj := range index.Objects {
+		if obj.Kind == "codemodel" {
+			codemodelPath = filepath.Join(replyDir, obj.JsonFile)
+			break
+		}
+	}
+	if codemodelPath == "" {
+		return fmt.Errorf("no codemodel object in %s", indexPath)
+	}
+
+	var codemodel codemodelReply
+	if err := readJSONFile(codemodelPath, &codemodel); err != nil {
+		return fmt.Errorf("parse %s: %w", codemodelPath, err)
+	}
+	if len(codemodel.Configurations) == 0 {
+		return fmt.Errorf("codemodel %s has no configurations", codemodelPath)
+	}
+
+	seen := map[string]bool{}
+	var includes []string
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			includes = append(includes, path)
+		}
+	}
+
+	for _, target := range codemodel.Configurations[0].Targets {
+		targetPath := filepath.Join(replyDir, target.JsonFile)
+		var t targetReply
+		if err := readJSONFile(targetPath, &t); err != nil {
+			return fmt.Errorf("parse target %q (%s): %w", target.Name, targetPath, err)
+		}
+		for _, group := range t.CompileGroups {
+			for _, inc := range group.Includes {
+				add(inc.Path)
+			}
+		}
+	}
+	add(configDir)
+	sort.Strings(includes)
+
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, path := range includes {
+		fmt.Fprintln(f, path)
+	}
+	return nil
+}
+
+// latestIndexFile returns the most recently written index-*.json in
+// replyDir. A build dir that's been configured more than once can have
+// several; only the newest reflects this run.
+func latestIndexFile(replyDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(replyDir, "index-*.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no CMake file API reply found in %s; was the query file written before idf.py's first configure?", replyDir)
+	}
+
+	latest := matches[0]
+	latestMod, err := os.Stat(latest)
+	if err != nil {
+		return "", err
+	}
+	latestTime := latestMod.ModTime()
+	for _, m := range matches[1:] {
+		info, err := os.Stat(m)
+		if err != nil {
+			return "", err
+		}
+		if info.ModTime().After(latestTime) {
+			latest, latestTime = m, info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// readJSONFile decodes path's JSON contents into v.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// relocateCompileCommands copies buildDir/compile_commands.json (emitted
+// because the generated CMakeLists.txt sets CMAKE_EXPORT_COMPILE_COMMANDS)
+// to workspaceRoot, where clangd and Zig's build.zig both look for it by
+// default. workspaceRoot empty (BUILD_WORKSPACE_DIRECTORY unset, e.g.
+// outside `bazel run`) just skips this -- it's a developer-tooling
+// convenience, not something the firmware build depends on.
+func relocateCompileCommands(buildDir, workspaceRoot string) string {
+	if workspaceRoot == "" {
+		return ""
+	}
+	src := filepath.Join(buildDir, "compile_commands.json")
+	if _, err := os.Stat(src); err != nil {
+		return ""
+	}
+	dst := filepath.Join(workspaceRoot, "compile_commands.json")
+	if err := copyFile(src, dst); err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_configure] Warning: failed to relocate compile_commands.json: %v\n", err)
+		return ""
+	}
+	return dst
+}
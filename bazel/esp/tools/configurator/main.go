@@ -1,13 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strings"
+
+	"embed-zig/bazel/esp/tools/common"
 )
 
 func main() {
@@ -28,11 +27,32 @@ func main() {
 	}
 
 	// Setup environment
-	setupHome()
-	if err := setupIDFEnv(); err != nil {
+	common.SetupHome()
+	if err := common.SetupIDFEnv("", "[esp_configure]"); err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_configure] Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Extract chip type from sdkconfig.defaults directly -- it's part of
+	// the cache key, so this needs to happen before the cache lookup below.
+	chip, err := common.ExtractChipFromSdkconfig(sdkconfigPath, "")
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "[esp_configure] Error: %v\n", err)
 		os.Exit(1)
 	}
+	fmt.Printf("[esp_configure] Chip: %s\n", chip)
+
+	workspaceRoot := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	key, err := cacheKey(sdkconfigPath, idfVersion(), chip, requires, idfComponentYml)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_configure] Error: Failed to compute cache key: %v\n", err)
+		os.Exit(1)
+	}
+	if loadCache(key, configDir, includeDirsFile, workspaceRoot) {
+		fmt.Printf("[esp_configure] Cache hit (%s); skipping idf.py set-target/reconfigure\n", key[:12])
+		fmt.Printf("[esp_configure] Done. Config at %s\n", configDir)
+		return
+	}
 
 	// Verify idf.py is available
 	if _, err := exec.LookPath("idf.py"); err != nil {
@@ -55,8 +75,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Generate minimal CMakeLists.txt
+	// Generate minimal CMakeLists.txt. CMAKE_EXPORT_COMPILE_COMMANDS is set
+	// here, before project(), so the generated compile_commands.json covers
+	// every target -- relocateCompileCommands picks it up below.
 	cmakeContent := `cmake_minimum_required(VERSION 3.16)
+set(CMAKE_EXPORT_COMPILE_COMMANDS ON)
 include($ENV{IDF_PATH}/tools/cmake/project.cmake)
 project(esp_configure)
 `
@@ -98,13 +121,14 @@ project(esp_configure)
 		os.Exit(1)
 	}
 
-	// Extract chip type from sdkconfig
-	chip, err := extractChipFromSdkconfig(filepath.Join(projectDir, "sdkconfig.defaults"))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[esp_configure] Error: %v\n", err)
+	// Drop the CMake file API query before the build dir's first configure
+	// (idf.py set-target runs one), so the reply codemodel-v2 parsed by
+	// extractIncludeDirs below actually exists.
+	buildDir := filepath.Join(projectDir, "build")
+	if err := writeCMakeFileAPIQuery(buildDir); err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_configure] Error: Failed to write CMake file API query: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("[esp_configure] Chip: %s\n", chip)
 
 	// Change to project directory
 	if err := os.Chdir(projectDir); err != nil {
@@ -125,158 +149,28 @@ project(esp_configure)
 	}
 
 	// Copy generated config directory
-	buildConfigDir := filepath.Join(projectDir, "build", "config")
+	buildConfigDir := filepath.Join(buildDir, "config")
 	if err := copyDir(buildConfigDir, configDir); err != nil {
 		fmt.Fprintf(os.Stderr, "[esp_configure] Error: Failed to copy config dir: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Extract include directories
+	// Extract include directories from the CMake file API reply
 	fmt.Println("[esp_configure] Extracting include directories...")
-	if err := extractIncludeDirs(projectDir, includeDirsFile, configDir); err != nil {
+	if err := extractIncludeDirs(buildDir, includeDirsFile, configDir); err != nil {
 		fmt.Fprintf(os.Stderr, "[esp_configure] Error: Failed to extract include dirs: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("[esp_configure] Done. Config at %s\n", configDir)
-}
-
-// setupHome sets HOME if not already set.
-func setupHome() {
-	if os.Getenv("HOME") == "" {
-		idfPath := os.Getenv("IDF_PATH")
-		if idfPath != "" {
-			re := regexp.MustCompile(`^(/[^/]+/[^/]+)/`)
-			matches := re.FindStringSubmatch(idfPath)
-			if len(matches) > 1 {
-				os.Setenv("HOME", matches[1])
-				return
-			}
-		}
-		os.Setenv("HOME", "/tmp")
-	}
-}
-
-// setupIDFEnv sets up ESP-IDF environment (PATH and IDF_PYTHON).
-func setupIDFEnv() error {
-	home := os.Getenv("HOME")
-	pythonEnvDir := filepath.Join(home, ".espressif", "python_env")
-
-	var idfPythonEnv string
-	if _, err := os.Stat(pythonEnvDir); err == nil {
-		entries, err := os.ReadDir(pythonEnvDir)
-		if err == nil {
-			for _, entry := range entries {
-				if entry.IsDir() && strings.HasPrefix(entry.Name(), "idf") && strings.HasSuffix(entry.Name(), "_env") {
-					envPath := filepath.Join(pythonEnvDir, entry.Name())
-					pythonPath := filepath.Join(envPath, "bin", "python")
-					if _, err := os.Stat(pythonPath); err == nil {
-						idfPythonEnv = envPath
-						break
-					}
-				}
-			}
-		}
-	}
-
-	if idfPythonEnv == "" {
-		os.Setenv("IDF_PYTHON", "python3")
-		return nil
-	}
-
-	// Build PATH with ESP-IDF tools
-	espressifTools := filepath.Join(home, ".espressif", "tools")
-	var idfToolsPaths []string
-
-	if _, err := os.Stat(espressifTools); err == nil {
-		filepath.Walk(espressifTools, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
-			}
-			if info.IsDir() && info.Name() == "bin" {
-				rel, _ := filepath.Rel(espressifTools, path)
-				if strings.Count(rel, string(os.PathSeparator)) <= 3 {
-					idfToolsPaths = append(idfToolsPaths, path)
-				}
-			}
-			return nil
-		})
-	}
-
-	idfPath := os.Getenv("IDF_PATH")
-	pathComponents := []string{filepath.Join(idfPythonEnv, "bin")}
-	pathComponents = append(pathComponents, idfToolsPaths...)
-	if idfPath != "" {
-		pathComponents = append(pathComponents, filepath.Join(idfPath, "tools"))
+	if ccPath := relocateCompileCommands(buildDir, workspaceRoot); ccPath != "" {
+		fmt.Printf("[esp_configure] compile_commands.json -> %s\n", ccPath)
 	}
-	pathComponents = append(pathComponents, os.Getenv("PATH"))
-
-	newPath := strings.Join(pathComponents, string(os.PathListSeparator))
-	os.Setenv("PATH", newPath)
-	os.Setenv("IDF_PYTHON", filepath.Join(idfPythonEnv, "bin", "python"))
 
-	return nil
-}
-
-// extractChipFromSdkconfig extracts CONFIG_IDF_TARGET from sdkconfig.defaults.
-func extractChipFromSdkconfig(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	if err := saveCache(key, configDir, includeDirsFile, buildDir, workspaceRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_configure] Warning: failed to cache config tree: %v\n", err)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	re := regexp.MustCompile(`^CONFIG_IDF_TARGET="(.+)"`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := re.FindStringSubmatch(line); len(matches) > 1 {
-			return matches[1], nil
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("error reading %s: %w", path, err)
-	}
-
-	return "", fmt.Errorf("CONFIG_IDF_TARGET not found in %s", path)
-}
 
-// extractIncludeDirs extracts include directories from CMake and writes to file.
-func extractIncludeDirs(projectDir, outputFile, configDir string) error {
-	f, err := os.Create(outputFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Get include dirs from CMake cache
-	buildDir := filepath.Join(projectDir, "build")
-	cmd := exec.Command("cmake", "-L", buildDir)
-	output, err := cmd.Output()
-	if err != nil {
-		// Ignore error, CMake might not have all info we need
-	}
-
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	re := regexp.MustCompile(`(_INCLUDE_DIRS|_DIR)=(.+)`)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := re.FindStringSubmatch(line); len(matches) > 2 {
-			fmt.Fprintln(f, matches[2])
-		}
-	}
-
-	// Add standard paths that are always needed
-	fmt.Fprintln(f, configDir)
-	idfPath := os.Getenv("IDF_PATH")
-	if idfPath != "" {
-		fmt.Fprintln(f, filepath.Join(idfPath, "components", "esp_common", "include"))
-		fmt.Fprintln(f, filepath.Join(idfPath, "components", "esp_system", "include"))
-	}
-
-	return nil
+	fmt.Printf("[esp_configure] Done. Config at %s\n", configDir)
 }
 
 // runCommand runs a command and streams output to stdout/stderr.
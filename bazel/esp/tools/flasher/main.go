@@ -1,24 +1,35 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"embed-zig/bazel/common/serial"
+	"embed-zig/bazel/esp/tools/common"
+	"embed-zig/lib/pkg/imgsig"
 )
 
 type Config struct {
 	Board          string
+	Chip           string
 	Baud           string
 	Port           string
 	BinPath        string
 	BootloaderPath string
 	PartitionPath  string
+	ElfPath        string
 	FullFlash      bool
 	DataFlashArgs  string
 	NVSOffset      string
@@ -31,16 +42,38 @@ func main() {
 	// Parse command-line flags
 	appOnly := flag.Bool("app-only", false, "Flash app partition only (skip bootloader and partition table)")
 	eraseNVS := flag.Bool("erase-nvs", false, "Erase NVS partition before flashing")
+	monitorAfter := flag.Bool("monitor", false, "Open a serial monitor after flashing")
+	noReset := flag.Bool("no-reset", false, "Attach the monitor without resetting the chip (implies --monitor)")
+	output := flag.String("output", "", "Output path for merge-bin/save-image")
+	format := flag.String("format", "raw", "merge-bin/save-image format: raw, hex, or uf2")
+	flashSize := flag.String("flash-size", "4MB", "merge-bin/save-image target flash size: 2MB, 4MB, 8MB, or 16MB")
+	signKey := flag.String("sign-key", "", "Sign cfg.BinPath with this PEM private key before flashing (MCUboot-style header+TLV)")
+	signAlg := flag.String("sign-alg", "ecdsa-p256", "Signing algorithm for --sign-key: ecdsa-p256 or rsa-2048")
+	verifyKey := flag.String("verify-key", "", "Verify the image against this PEM public key before flashing")
+	verifyOnly := flag.Bool("verify-only", false, "Verify the image and exit without flashing")
+	force := flag.Bool("force", false, "Flash even if --verify-key signature verification fails")
+	printBoardInfo := flag.Bool("print-board-info", false, "Detect the connected chip and print a JSON board-info blob, then exit")
 	flag.Parse()
 
+	// `bazel run //path:flash -- monitor` attaches without flashing at all;
+	// `-- merge-bin` (or the espflash-style alias `save-image`) skips
+	// flashing and esptool entirely to produce a factory image instead.
+	monitorOnly := flag.Arg(0) == "monitor"
+	mergeBin := flag.Arg(0) == "merge-bin" || flag.Arg(0) == "save-image"
+	if *noReset {
+		*monitorAfter = true
+	}
+
 	// Load configuration from environment (set by Bazel rule)
 	cfg := Config{
 		Board:          os.Getenv("ESP_BOARD"),
+		Chip:           os.Getenv("ESP_CHIP"),
 		Baud:           os.Getenv("ESP_BAUD"),
 		Port:           os.Getenv("ESP_PORT_CONFIG"),
 		BinPath:        os.Getenv("ESP_BIN"),
 		BootloaderPath: os.Getenv("ESP_BOOTLOADER"),
 		PartitionPath:  os.Getenv("ESP_PARTITION"),
+		ElfPath:        os.Getenv("ESP_ELF"),
 		FullFlash:      os.Getenv("ESP_FULL_FLASH") == "1",
 		DataFlashArgs:  os.Getenv("ESP_DATA_FLASH_ARGS"),
 		NVSOffset:      os.Getenv("ESP_NVS_OFFSET"),
@@ -64,17 +97,62 @@ func main() {
 	if cfg.PartitionPath != "" {
 		cfg.PartitionPath = resolvePath(r, cfg.PartitionPath)
 	}
+	if cfg.ElfPath != "" {
+		cfg.ElfPath = resolvePath(r, cfg.ElfPath)
+	}
 
-	// Setup environment
-	setupHome()
+	if *printBoardInfo {
+		setupHome()
+		port, err := detectSerialPort(cfg.Port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Port = port
+		killPortProcess(cfg.Port)
 
-	// Find ESP-IDF Python
-	idfPython, err := findIDFPython()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
-		os.Exit(1)
+		idfPython, err := findIDFPython()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+			os.Exit(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		info, err := detectBoardInfo(ctx, idfPython, cfg.Port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: chip detection failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := printBoardInfoJSON(info); err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !monitorOnly {
+		proceed, err := applyImageSigning(&cfg, *signKey, *signAlg, *verifyKey, *verifyOnly, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !proceed {
+			return
+		}
 	}
 
+	if mergeBin {
+		if err := runMergeBin(cfg, *output, *format, *flashSize); err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Setup environment
+	setupHome()
+
 	// Detect serial port
 	port, err := detectSerialPort(cfg.Port)
 	if err != nil {
@@ -86,90 +164,337 @@ func main() {
 	// Kill any process using the port
 	killPortProcess(cfg.Port)
 
-	fmt.Printf("[esp_flash] Board: %s\n", cfg.Board)
-	fmt.Printf("[esp_flash] Flashing to %s at %s baud...\n", cfg.Port, cfg.Baud)
-	fmt.Printf("[esp_flash] Binary: %s\n", cfg.BinPath)
-
-	// Detect reset mode based on port type
-	beforeReset := "default_reset"
-	afterReset := "hard_reset"
-	usbJTAGMode := false
-	if strings.Contains(cfg.Port, "usbmodem") {
-		beforeReset = "usb_reset"
-		afterReset = "no_reset"
-		usbJTAGMode = true
-		fmt.Println("[esp_flash] Using USB-JTAG mode (watchdog reset after flash)")
-	}
-
-	// Erase NVS if requested
-	if cfg.EraseNVS {
-		nvsOffset := cfg.NVSOffset
-		nvsSize := cfg.NVSSize
-		if nvsOffset == "" || nvsSize == "" {
-			fmt.Println("[esp_flash] Warning: NVS partition info not available, using default (0x9000, 0x6000)")
-			nvsOffset = "0x9000"
-			nvsSize = "0x6000"
-		}
-		fmt.Printf("[esp_flash] Erasing NVS partition at %s (size: %s)...\n", nvsOffset, nvsSize)
-		args := []string{"-m", "esptool", "--port", cfg.Port, "--baud", cfg.Baud,
-			"--before", beforeReset, "--after", "no_reset",
-			"erase_region", nvsOffset, nvsSize}
-		if err := runCommand(idfPython, args...); err != nil {
-			fmt.Fprintf(os.Stderr, "[esp_flash] Error: NVS erase failed: %v\n", err)
+	if !monitorOnly {
+		// Find ESP-IDF Python
+		idfPython, err := findIDFPython()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
 			os.Exit(1)
 		}
-	}
 
-	// Build flash arguments
-	var flashArgs []string
-	if cfg.AppOnly {
-		fmt.Println("[esp_flash] App-only mode")
-		flashArgs = []string{"0x10000", cfg.BinPath}
-	} else if cfg.FullFlash {
-		fmt.Println("[esp_flash] Full flash mode (bootloader + partition + app)")
-		flashArgs = []string{
-			"0x0", cfg.BootloaderPath,
-			"0x8000", cfg.PartitionPath,
-			"0x10000", cfg.BinPath,
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Printf("[esp_flash] Board: %s\n", cfg.Board)
+		fmt.Printf("[esp_flash] Flashing to %s at %s baud...\n", cfg.Port, cfg.Baud)
+		fmt.Printf("[esp_flash] Binary: %s\n", cfg.BinPath)
+
+		// Detect the connected chip so we can catch a binary/board mismatch
+		// before writing anything, and so write_flash gets the part's actual
+		// flash_size/flash_mode/flash_freq instead of guessing.
+		var info common.BoardInfo
+		if detected, err := detectBoardInfo(ctx, idfPython, cfg.Port); err != nil {
+			fmt.Printf("[esp_flash] Warning: chip auto-detect failed, using configured parameters: %v\n", err)
+		} else {
+			info = detected
+			fmt.Printf("[esp_flash] Detected %s (revision %s), flash size %s\n", info.Chip, info.Revision, info.FlashSize)
+			if cfg.Chip != "" {
+				if err := common.CheckChipMatch(cfg.Chip, info.Chip); err != nil {
+					fmt.Fprintf(os.Stderr, "[esp_flash] Error: %v\n", err)
+					os.Exit(1)
+				}
+			}
 		}
-		// Add data partitions if any
-		if cfg.DataFlashArgs != "" {
-			parts := strings.Fields(cfg.DataFlashArgs)
-			flashArgs = append(flashArgs, parts...)
-			fmt.Println("[esp_flash] Including data partitions")
+
+		// Detect reset mode based on port type
+		beforeReset := "default_reset"
+		afterReset := "hard_reset"
+		usbJTAGMode := false
+		if strings.Contains(cfg.Port, "usbmodem") {
+			beforeReset = "usb_reset"
+			afterReset = "no_reset"
+			usbJTAGMode = true
+			fmt.Println("[esp_flash] Using USB-JTAG mode (watchdog reset after flash)")
 		}
-	} else {
-		flashArgs = []string{"0x10000", cfg.BinPath}
-	}
 
-	// Run esptool
-	args := []string{"-m", "esptool", "--port", cfg.Port, "--baud", cfg.Baud,
-		"--before", beforeReset, "--after", afterReset,
-		"write_flash", "-z"}
-	args = append(args, flashArgs...)
+		// Erase NVS if requested
+		if cfg.EraseNVS {
+			nvsOffset := cfg.NVSOffset
+			nvsSize := cfg.NVSSize
+			if nvsOffset == "" || nvsSize == "" {
+				fmt.Println("[esp_flash] Warning: NVS partition info not available, using default (0x9000, 0x6000)")
+				nvsOffset = "0x9000"
+				nvsSize = "0x6000"
+			}
+			fmt.Printf("[esp_flash] Erasing NVS partition at %s (size: %s)...\n", nvsOffset, nvsSize)
+			args := []string{"-m", "esptool", "--port", cfg.Port, "--baud", cfg.Baud,
+				"--before", beforeReset, "--after", "no_reset",
+				"erase_region", nvsOffset, nvsSize}
+			if err := runCommand(ctx, idfPython, args...); err != nil {
+				fmt.Fprintf(os.Stderr, "[esp_flash] Error: NVS erase failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
 
-	if err := runCommand(idfPython, args...); err != nil {
-		fmt.Fprintf(os.Stderr, "[esp_flash] Error: Flash failed: %v\n", err)
-		os.Exit(1)
-	}
+		// Compute the real app offset from the partition table instead of
+		// assuming the common default — a non-default partition layout (e.g.
+		// a smaller bootloader reservation) puts the app somewhere else.
+		appOffset := int64(0x10000)
+		if cfg.PartitionPath != "" {
+			if entries, err := common.ParsePartitionFile(cfg.PartitionPath); err != nil {
+				fmt.Printf("[esp_flash] Warning: could not read partition table (%v), assuming app offset 0x10000\n", err)
+			} else if off, err := common.AppOffset(entries); err != nil {
+				fmt.Printf("[esp_flash] Warning: %v, assuming app offset 0x10000\n", err)
+			} else {
+				appOffset = off
+			}
+		}
+		appOffsetHex := fmt.Sprintf("0x%x", appOffset)
+
+		// Build flash arguments
+		var flashArgs []string
+		if cfg.AppOnly {
+			fmt.Println("[esp_flash] App-only mode")
+			flashArgs = []string{appOffsetHex, cfg.BinPath}
+		} else if cfg.FullFlash {
+			fmt.Println("[esp_flash] Full flash mode (bootloader + partition + app)")
+			flashArgs = []string{
+				"0x0", cfg.BootloaderPath,
+				"0x8000", cfg.PartitionPath,
+				appOffsetHex, cfg.BinPath,
+			}
+			// Add data partitions if any
+			if cfg.DataFlashArgs != "" {
+				parts := strings.Fields(cfg.DataFlashArgs)
+				flashArgs = append(flashArgs, parts...)
+				fmt.Println("[esp_flash] Including data partitions")
+			}
+		} else {
+			flashArgs = []string{appOffsetHex, cfg.BinPath}
+		}
 
-	// For USB-JTAG, use watchdog reset
-	if usbJTAGMode {
-		fmt.Println("[esp_flash] Executing watchdog reset...")
-		pythonCode := fmt.Sprintf(`
+		// Run esptool
+		args := []string{"-m", "esptool", "--port", cfg.Port, "--baud", cfg.Baud,
+			"--before", beforeReset, "--after", afterReset,
+			"write_flash"}
+		if info.FlashSize != "" {
+			args = append(args, "--flash_size", info.FlashSize, "--flash_mode", info.FlashMode, "--flash_freq", info.FlashFreq)
+		}
+		args = append(args, "-z")
+		args = append(args, flashArgs...)
+
+		if err := runCommand(ctx, idfPython, args...); err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: Flash failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		// For USB-JTAG, use watchdog reset
+		if usbJTAGMode {
+			fmt.Println("[esp_flash] Executing watchdog reset...")
+			pythonCode := fmt.Sprintf(`
 import esptool
 esp = esptool.detect_chip('%s', 115200, 'usb_reset', False, 3)
 esp = esp.run_stub()
 esp.watchdog_reset()
 `, cfg.Port)
-		cmd := exec.Command(idfPython, "-c", pythonCode)
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		_ = cmd.Run() // Ignore errors
-		fmt.Println("[esp_flash] Watchdog reset complete (manual RST may be needed)")
+			cmd := exec.Command(idfPython, "-c", pythonCode)
+			cmd.Stdout = nil
+			cmd.Stderr = nil
+			_ = cmd.Run() // Ignore errors
+			fmt.Println("[esp_flash] Watchdog reset complete (manual RST may be needed)")
+		}
+
+		fmt.Println("[esp_flash] Flash complete!")
+	}
+
+	if monitorOnly || *monitorAfter {
+		if err := runMonitor(cfg, *noReset); err != nil {
+			fmt.Fprintf(os.Stderr, "[esp_flash] Error: Monitor failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runMonitor opens cfg.Port and streams it to the terminal, symbolicating
+// ESP32 panic backtraces against cfg.ElfPath when available. noReset leaves
+// DTR/RTS alone so the monitor can attach without resetting the chip.
+func runMonitor(cfg Config, noReset bool) error {
+	baud := os.Getenv("ESP_MONITOR_BAUD")
+	if baud == "" {
+		baud = "115200"
+	}
+	baudRate, err := strconv.Atoi(baud)
+	if err != nil {
+		return fmt.Errorf("invalid ESP_MONITOR_BAUD=%q: %w", baud, err)
+	}
+
+	opts := serial.MonitorOptions{
+		ResetOnConnect: !noReset,
+		LogPath:        os.Getenv("ESP_MONITOR_LOG"),
+	}
+
+	if cfg.ElfPath != "" {
+		sym, err := common.NewSymbolizer(cfg.ElfPath)
+		if err != nil {
+			fmt.Printf("[esp_flash] Warning: backtrace symbolication disabled: %v\n", err)
+		} else {
+			opts.Stdout = sym.Wrap(os.Stdout)
+		}
+	}
+
+	fmt.Printf("[esp_flash] Monitoring %s at %d baud...\n", cfg.Port, baudRate)
+	fmt.Println("[esp_flash] Press Ctrl+] to exit")
+
+	return serial.Monitor(cfg.Port, baudRate, opts)
+}
+
+// detectBoardInfo runs `esptool.py flash_id` against port and parses its
+// output into a BoardInfo, modeled on espflash's board-info/chip-autodetect.
+func detectBoardInfo(ctx context.Context, idfPython, port string) (common.BoardInfo, error) {
+	result, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name:            idfPython,
+		Args:            []string{"-m", "esptool", "--port", port, "flash_id"},
+		KillGracePeriod: 3 * time.Second,
+	})
+	if err != nil {
+		return common.BoardInfo{}, err
+	}
+	return common.ParseBoardInfo(string(result.Stdout))
+}
+
+// printBoardInfoJSON writes info to stdout as JSON, for use by other tools
+// (e.g. --print-board-info piped into a CI step).
+func printBoardInfoJSON(info common.BoardInfo) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+// applyImageSigning signs cfg.BinPath with --sign-key, rewriting cfg.BinPath
+// to point at the signed image, and/or verifies it against --verify-key,
+// refusing to continue on a bad signature unless force is set. It returns
+// false when the caller should stop without flashing (--verify-only).
+func applyImageSigning(cfg *Config, signKeyPath, signAlg, verifyKeyPath string, verifyOnly, force bool) (bool, error) {
+	if signKeyPath != "" {
+		key, err := imgsig.LoadPrivateKeyPEM(signKeyPath)
+		if err != nil {
+			return false, err
+		}
+		payload, err := os.ReadFile(cfg.BinPath)
+		if err != nil {
+			return false, err
+		}
+		signed, err := imgsig.Sign(payload, imgsig.SignOptions{Alg: imgsig.Algorithm(signAlg), Key: key})
+		if err != nil {
+			return false, err
+		}
+		signedPath := cfg.BinPath + ".signed"
+		if err := os.WriteFile(signedPath, signed, 0644); err != nil {
+			return false, err
+		}
+		fmt.Printf("[esp_flash] Signed %s (%s) -> %s\n", cfg.BinPath, signAlg, signedPath)
+		cfg.BinPath = signedPath
+	}
+
+	if verifyKeyPath != "" {
+		key, err := imgsig.LoadPublicKeyPEM(verifyKeyPath)
+		if err != nil {
+			return false, err
+		}
+		payload, err := os.ReadFile(cfg.BinPath)
+		if err != nil {
+			return false, err
+		}
+		if _, _, err := imgsig.Verify(payload, key); err != nil {
+			if !force {
+				return false, fmt.Errorf("image verification failed (use --force to flash anyway): %w", err)
+			}
+			fmt.Printf("[esp_flash] Warning: image verification failed, continuing due to --force: %v\n", err)
+		} else {
+			fmt.Println("[esp_flash] Image signature verified OK")
+		}
+		if verifyOnly {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// runMergeBin builds a single factory-programmable image from the
+// bootloader, partition table, app, and any ESP_DATA_FLASH_ARGS data
+// partitions, mirroring `esptool.py merge_bin`/espflash's `save-image`, and
+// writes it to outputPath in the requested format.
+func runMergeBin(cfg Config, outputPath, format, flashSize string) error {
+	if outputPath == "" {
+		return fmt.Errorf("--output is required")
+	}
+	if cfg.BootloaderPath == "" || cfg.PartitionPath == "" {
+		return fmt.Errorf("merge-bin requires a full-flash build (ESP_BOOTLOADER and ESP_PARTITION)")
+	}
+
+	size, err := common.FlashSizeBytes(flashSize)
+	if err != nil {
+		return err
+	}
+
+	var regions []common.Region
+	addRegion := func(offsetHex, path string) error {
+		offset, err := strconv.ParseInt(strings.TrimPrefix(offsetHex, "0x"), 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset %q: %w", offsetHex, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		regions = append(regions, common.Region{Offset: offset, Data: data})
+		return nil
+	}
+
+	if err := addRegion("0x0", cfg.BootloaderPath); err != nil {
+		return err
+	}
+	if err := addRegion("0x8000", cfg.PartitionPath); err != nil {
+		return err
+	}
+	if err := addRegion("0x10000", cfg.BinPath); err != nil {
+		return err
+	}
+	if cfg.DataFlashArgs != "" {
+		parts := strings.Fields(cfg.DataFlashArgs)
+		for i := 0; i+1 < len(parts); i += 2 {
+			if err := addRegion(parts[i], parts[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	image, err := common.MergeRegions(regions, size)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outputPath, err)
+	}
+	defer out.Close()
+
+	switch format {
+	case "raw":
+		_, err = out.Write(image)
+	case "hex":
+		err = common.WriteIntelHex(out, image, 0)
+	case "uf2":
+		chip := cfg.Chip
+		if chip == "" {
+			chip = cfg.Board
+		}
+		var familyID uint32
+		familyID, err = common.UF2FamilyID(chip)
+		if err == nil {
+			err = common.WriteUF2(out, image, 0, familyID)
+		}
+	default:
+		err = fmt.Errorf("unsupported --format %q (want raw, hex, or uf2)", format)
+	}
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("[esp_flash] Flash complete!")
+	fmt.Printf("[esp_flash] Wrote merged %s image (%d bytes) to %s\n", format, len(image), outputPath)
+	return nil
 }
 
 // resolvePath resolves a path using Bazel runfiles.
@@ -279,10 +604,13 @@ func killPortProcess(port string) {
 	}
 }
 
-// runCommand runs a command and prints output.
-func runCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// runCommand runs a command and prints output, honoring ctx cancellation
+// (e.g. a Ctrl-C'd `bazel run` killing a dangling esptool process).
+func runCommand(ctx context.Context, name string, args ...string) error {
+	_, err := common.RunCommandCtx(ctx, common.RunOptions{
+		Name:            name,
+		Args:            args,
+		KillGracePeriod: 3 * time.Second,
+	})
+	return err
 }
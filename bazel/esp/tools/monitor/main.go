@@ -1,14 +1,18 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strings"
+	"strconv"
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"embed-zig/bazel/common/serial"
+	"embed-zig/bazel/esp/tools/common"
 )
 
 type Config struct {
@@ -18,6 +22,13 @@ type Config struct {
 }
 
 func main() {
+	logPath := flag.String("log", os.Getenv("ESP_MONITOR_LOG"), "Tee raw monitor output to this file")
+	timestamps := flag.Bool("timestamps", false, "Prefix each line with a receive timestamp")
+	noColor := flag.Bool("no-color", false, "Disable ANSI color coding of ESP-IDF log levels and panic headers")
+	reconnect := flag.Bool("reconnect", false, "Re-open the port and keep monitoring if it disappears (USB replug/board reset) instead of exiting")
+	elfFlag := flag.String("elf", os.Getenv("ESP_ELF"), "Path (or Bazel runfiles path) to the unstripped ELF, for panic backtrace symbolication")
+	flag.Parse()
+
 	// Load configuration from environment (set by Bazel rule)
 	cfg := Config{
 		Board: os.Getenv("ESP_BOARD"),
@@ -25,23 +36,20 @@ func main() {
 		Port:  os.Getenv("ESP_PORT_CONFIG"),
 	}
 
-	// Initialize runfiles (not used for monitor, but keep for consistency)
-	_, err := runfiles.New()
+	r, err := runfiles.New()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[esp_monitor] Error: Failed to initialize runfiles: %v\n", err)
 		os.Exit(1)
 	}
 
+	elfPath := *elfFlag
+	if elfPath != "" {
+		elfPath = resolvePath(r, elfPath)
+	}
+
 	// Setup environment
 	setupHome()
 
-	// Find ESP-IDF Python
-	idfPython, err := findIDFPython()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[esp_monitor] Error: %v\n", err)
-		os.Exit(1)
-	}
-
 	// Detect serial port
 	port, err := detectSerialPort(cfg.Port)
 	if err != nil {
@@ -55,43 +63,51 @@ func main() {
 
 	fmt.Printf("[esp_monitor] Board: %s\n", cfg.Board)
 	fmt.Printf("[esp_monitor] Monitoring %s at %s baud...\n", cfg.Port, cfg.Baud)
-	fmt.Println("[esp_monitor] Press Ctrl+C to exit")
-
-	// Run Python serial monitor
-	pythonCode := fmt.Sprintf(`
-import serial
-import sys
-
-try:
-    ser = serial.Serial('%s', %s, timeout=0.5)
-    ser.setDTR(False)  # Don't trigger reset
-    ser.setRTS(False)
-    print('Connected to %s at %s baud')
-    print('Waiting for data... (press RST on device if needed)')
-    print('---')
-    while True:
-        data = ser.read(ser.in_waiting or 1)
-        if data:
-            text = data.decode('utf-8', errors='replace')
-            sys.stdout.write(text)
-            sys.stdout.flush()
-except KeyboardInterrupt:
-    print('\n--- Monitor stopped ---')
-except Exception as e:
-    print(f'Error: {e}')
-    sys.exit(1)
-`, cfg.Port, cfg.Baud, cfg.Port, cfg.Baud)
-
-	cmd := exec.Command(idfPython, "-c", pythonCode)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
+	fmt.Println("[esp_monitor] Press Ctrl+] to exit")
+
+	baud, err := strconv.Atoi(cfg.Baud)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[esp_monitor] Error: invalid ESP_MONITOR_BAUD=%q: %v\n", cfg.Baud, err)
+		os.Exit(1)
+	}
+
+	opts := serial.MonitorOptions{
+		ResetOnConnect: true,
+		LogPath:        *logPath,
+		Timestamps:     *timestamps,
+		Colorize:       !*noColor,
+	}
+	if elfPath != "" {
+		sym, err := common.NewSymbolizer(elfPath)
+		if err != nil {
+			fmt.Printf("[esp_monitor] Warning: backtrace symbolication disabled: %v\n", err)
+		} else {
+			opts.Stdout = sym.Wrap(os.Stdout)
+		}
+	}
+
+	monitor := serial.Monitor
+	if *reconnect {
+		monitor = serial.MonitorReconnecting
+	}
+	if err := monitor(cfg.Port, baud, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "[esp_monitor] Error: Monitor failed: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// resolvePath resolves a path using Bazel runfiles.
+func resolvePath(r *runfiles.Runfiles, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	resolved, err := r.Rlocation(path)
+	if err == nil && resolved != "" {
+		return resolved
+	}
+	return path
+}
+
 // setupHome sets HOME if not already set.
 func setupHome() {
 	if os.Getenv("HOME") == "" {
@@ -108,29 +124,6 @@ func setupHome() {
 	}
 }
 
-// findIDFPython finds the ESP-IDF Python interpreter.
-func findIDFPython() (string, error) {
-	home := os.Getenv("HOME")
-	pythonEnvDir := filepath.Join(home, ".espressif", "python_env")
-
-	if _, err := os.Stat(pythonEnvDir); err == nil {
-		entries, err := os.ReadDir(pythonEnvDir)
-		if err == nil {
-			for _, entry := range entries {
-				if entry.IsDir() && strings.HasPrefix(entry.Name(), "idf") && strings.HasSuffix(entry.Name(), "_env") {
-					pythonPath := filepath.Join(pythonEnvDir, entry.Name(), "bin", "python")
-					if _, err := os.Stat(pythonPath); err == nil {
-						return pythonPath, nil
-					}
-				}
-			}
-		}
-	}
-
-	fmt.Println("[esp_monitor] Warning: ESP-IDF Python env not found, using system python3")
-	return "python3", nil
-}
-
 // detectSerialPort auto-detects or validates the serial port.
 func detectSerialPort(configured string) (string, error) {
 	// Priority: configured > ESP_PORT env > auto-detect
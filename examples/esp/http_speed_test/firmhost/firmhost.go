@@ -0,0 +1,311 @@
+// Package firmhost serves firmware images and plain speed-test payloads over
+// HTTP, for exercising ESP32/BK7258 OTA robustness over flaky Wi-Fi.
+package firmhost
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Root is the directory OTA firmware assets are served from, laid out as
+	// <Root>/<target>/<version>/firmware.bin.
+	Root string
+
+	// ThrottleKbps caps outbound bandwidth per connection when > 0.
+	ThrottleKbps int
+
+	// InjectDropPercent, 0-100, is the chance a streaming response is cut
+	// short mid-transfer to exercise client-side resume logic.
+	InjectDropPercent int
+}
+
+// Server serves the legacy /test/<size> speed-test payloads alongside the
+// /ota/<target>/<version>/firmware.bin asset tree.
+type Server struct {
+	cfg Config
+}
+
+// New creates a Server from cfg.
+func New(cfg Config) *Server {
+	return &Server{cfg: cfg}
+}
+
+// Handler returns the http.Handler serving both the OTA and legacy routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ota/", s.handleOTA)
+	mux.HandleFunc("/test/", s.handleLegacyTest) // thin alias, kept for existing speed measurements
+	return mux
+}
+
+// handleOTA routes /ota/<target>/latest and /ota/<target>/<version>/firmware.bin.
+func (s *Server) handleOTA(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/ota/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "latest":
+		s.handleLatest(w, r, parts[0])
+	case len(parts) == 3 && parts[2] == "firmware.bin":
+		s.handleFirmware(w, r, parts[0], parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// compareVersions compares two dotted-numeric version strings (e.g. "1.0.9"
+// vs "1.0.10") component by component, numerically, so a two-digit
+// component doesn't lose to os.ReadDir's lexical ordering the way
+// "1.0.10" < "1.0.9" would under plain string comparison. Non-numeric
+// components fall back to a string compare of that component.
+func compareVersions(a, b string) int {
+	pa := strings.Split(a, ".")
+	pb := strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var sa, sb string
+		if i < len(pa) {
+			sa = pa[i]
+		}
+		if i < len(pb) {
+			sb = pb[i]
+		}
+		na, errA := strconv.Atoi(sa)
+		nb, errB := strconv.Atoi(sb)
+		if errA != nil || errB != nil {
+			if sa != sb {
+				return strings.Compare(sa, sb)
+			}
+			continue
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
+
+// handleLatest returns the newest version directory under <Root>/<target> and
+// its firmware SHA256 as JSON.
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request, target string) {
+	targetDir := filepath.Join(s.cfg.Root, target)
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		http.Error(w, "unknown target", http.StatusNotFound)
+		return
+	}
+
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() {
+			versions = append(versions, e.Name())
+		}
+	}
+	if len(versions) == 0 {
+		http.Error(w, "no firmware versions found", http.StatusNotFound)
+		return
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	version := versions[len(versions)-1]
+
+	path := filepath.Join(targetDir, version, "firmware.bin")
+	sum, err := sha256File(path)
+	if err != nil {
+		http.Error(w, "firmware.bin missing for latest version", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"target":  target,
+		"version": version,
+		"sha256":  sum,
+	})
+}
+
+// handleFirmware serves a firmware.bin through http.ServeContent so Range and
+// If-None-Match (ETag) requests work, then applies throttling/drop injection.
+func (s *Server) handleFirmware(w http.ResponseWriter, r *http.Request, target, version string) {
+	path := filepath.Join(s.cfg.Root, target, version, "firmware.bin")
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "firmware not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "stat failed", http.StatusInternalServerError)
+		return
+	}
+
+	sum, err := sha256File(path)
+	if err == nil {
+		w.Header().Set("ETag", `"`+sum+`"`)
+	}
+
+	ww := s.wrapWriter(w, r.Context())
+	http.ServeContent(ww, r, "firmware.bin", info.ModTime(), f)
+}
+
+// handleLegacyTest is a thin alias to the original /test/<size> handler so
+// existing speed measurements keep working unmodified.
+func (s *Server) handleLegacyTest(w http.ResponseWriter, r *http.Request) {
+	sizeStr := strings.TrimPrefix(r.URL.Path, "/test/")
+
+	var size int
+	switch sizeStr {
+	case "10m":
+		size = 10 * 1024 * 1024
+	case "50m":
+		size = 50 * 1024 * 1024
+	default:
+		var err error
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 || size > 100*1024*1024 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Printf("[%s] GET /test/%s", r.RemoteAddr, sizeStr)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+
+	ww := s.wrapWriter(w, r.Context())
+	chunk := make([]byte, 16*1024)
+	written := 0
+	for written < size {
+		n := len(chunk)
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := ww.Write(chunk[:n]); err != nil {
+			log.Printf("[%s] write stopped after %d bytes: %v", r.RemoteAddr, written, err)
+			return
+		}
+		written += n
+	}
+}
+
+// wrapWriter applies bandwidth throttling and drop injection around w,
+// falling back to w unchanged when neither is configured.
+func (s *Server) wrapWriter(w http.ResponseWriter, ctx context.Context) http.ResponseWriter {
+	if s.cfg.ThrottleKbps <= 0 && s.cfg.InjectDropPercent <= 0 {
+		return w
+	}
+	return &throttledWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		limiter:        newLimiter(s.cfg.ThrottleKbps),
+		dropPercent:    s.cfg.InjectDropPercent,
+	}
+}
+
+func newLimiter(kbps int) *rate.Limiter {
+	if kbps <= 0 {
+		return nil
+	}
+	bytesPerSec := kbps * 1024 / 8
+	return rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+}
+
+// throttledWriter wraps a ResponseWriter with a token-bucket bandwidth cap and
+// an optional mid-stream connection drop, so clients can be tested against
+// flaky-Wi-Fi resume logic.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	limiter     *rate.Limiter
+	dropPercent int
+	written     int
+	dropAt      int // byte offset to drop at, chosen once lazily
+	dropChosen  bool
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if !t.dropChosen {
+		t.dropChosen = true
+		if t.dropPercent > 0 && randPercent() < t.dropPercent {
+			t.dropAt = t.written + len(p)/2 // drop partway into the first write
+		} else {
+			t.dropAt = -1
+		}
+	}
+
+	total := 0
+	for len(p) > 0 {
+		if t.dropAt >= 0 && t.written >= t.dropAt {
+			return total, fmt.Errorf("firmhost: injected connection drop at byte %d", t.written)
+		}
+		chunk := p
+		if t.dropAt >= 0 && t.written+len(chunk) > t.dropAt {
+			chunk = chunk[:t.dropAt-t.written]
+		}
+		if t.limiter != nil && len(chunk) > t.limiter.Burst() {
+			chunk = chunk[:t.limiter.Burst()]
+		}
+		if t.limiter != nil {
+			if err := t.limiter.WaitN(t.ctx, len(chunk)); err != nil {
+				return total, err
+			}
+		}
+		n, err := t.ResponseWriter.Write(chunk)
+		total += n
+		t.written += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		if t.dropAt >= 0 && t.written >= t.dropAt {
+			return total, fmt.Errorf("firmhost: injected connection drop at byte %d", t.written)
+		}
+	}
+	return total, nil
+}
+
+// Flush lets http.ServeContent's internal flusher checks succeed.
+func (t *throttledWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func randPercent() int {
+	n, err := rand.Int(rand.Reader, big.NewInt(100))
+	if err != nil {
+		return mrand.Intn(100)
+	}
+	return int(n.Int64())
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -1,8 +1,11 @@
 // HTTP Speed Test Server - Go Version
 // High performance server for ESP32 HTTP speed testing
 //
-// Usage: go run server.go
-// Or build: go build -o server server.go && ./server
+// Usage: go run server.go [port] [rate-limit]
+// Or build: go build -o server server.go && ./server [port] [rate-limit]
+//
+// rate-limit caps outbound bandwidth in bytes/sec (e.g. "256k"); 0 or
+// omitted is unlimited. A request can override it per-test with ?rate=.
 
 package main
 
@@ -16,6 +19,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"embed-zig/lib/pkg/ratelimit"
 )
 
 const (
@@ -23,6 +28,10 @@ const (
 	chunkSize   = 64 * 1024 // 64KB chunks for streaming
 )
 
+// defaultRateLimit is the optional rate-limit argument, in bytes/sec (0 =
+// unlimited). A request's own ?rate= query parameter overrides it.
+var defaultRateLimit float64
+
 // Generate test data (random-ish bytes)
 func generateData(size int) []byte {
 	data := make([]byte, size)
@@ -43,17 +52,23 @@ var testData = map[string][]byte{
 
 func testHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/test/")
-	
+
+	rate, err := requestRateLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w = rateLimitWriter(w, rate)
+
 	var data []byte
 	var size int
-	
+
 	// Check if it's a predefined size
 	if pregenerated, ok := testData[path]; ok {
 		data = pregenerated
 		size = len(data)
 	} else {
 		// Parse as bytes count
-		var err error
 		size, err = strconv.Atoi(path)
 		if err != nil || size <= 0 {
 			http.Error(w, "Invalid size", http.StatusBadRequest)
@@ -66,21 +81,55 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		data = generateData(size)
 	}
-	
+
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.Itoa(size))
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Write(data)
 }
 
+// requestRateLimit returns the bytes/sec cap for r: its own ?rate= query
+// parameter if set (e.g. "256k" for a 256KB/s cap), else defaultRateLimit.
+func requestRateLimit(r *http.Request) (float64, error) {
+	if q := r.URL.Query().Get("rate"); q != "" {
+		return ratelimit.ParseRate(q)
+	}
+	return defaultRateLimit, nil
+}
+
+// rateLimitWriter wraps w with a ratelimit.Writer when bytesPerSec > 0,
+// while keeping the http.Flusher streamLargeData relies on for periodic
+// flushing.
+func rateLimitWriter(w http.ResponseWriter, bytesPerSec float64) http.ResponseWriter {
+	if bytesPerSec <= 0 {
+		return w
+	}
+	return &rateLimitedResponseWriter{ResponseWriter: w, rl: ratelimit.NewWriter(w, bytesPerSec)}
+}
+
+type rateLimitedResponseWriter struct {
+	http.ResponseWriter
+	rl *ratelimit.Writer
+}
+
+func (rw *rateLimitedResponseWriter) Write(p []byte) (int, error) {
+	return rw.rl.Write(p)
+}
+
+func (rw *rateLimitedResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func streamLargeData(w http.ResponseWriter, size int) {
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.Itoa(size))
 	w.Header().Set("Cache-Control", "no-cache")
-	
+
 	chunk := generateData(chunkSize)
 	written := 0
-	
+
 	for written < size {
 		toWrite := chunkSize
 		if written+toWrite > size {
@@ -92,7 +141,7 @@ func streamLargeData(w http.ResponseWriter, size int) {
 			return
 		}
 		written += n
-		
+
 		// Flush periodically for better streaming
 		if f, ok := w.(http.Flusher); ok {
 			f.Flush()
@@ -138,7 +187,14 @@ func main() {
 			port = p
 		}
 	}
-	
+	if len(os.Args) > 2 {
+		rate, err := ratelimit.ParseRate(os.Args[2])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defaultRateLimit = rate
+	}
+
 	localIP := getLocalIP()
 	
 	http.HandleFunc("/test/", testHandler)
@@ -165,7 +221,11 @@ func main() {
 	fmt.Println("  /test/1m       - Download 1MB")
 	fmt.Println("  /test/10m      - Download 10MB")
 	fmt.Println("  /test/<bytes>  - Download custom size")
+	fmt.Println("  ?rate=256k     - Per-request bandwidth cap (bytes/sec, K/M suffix)")
 	fmt.Println()
+	if defaultRateLimit > 0 {
+		fmt.Printf("Default rate limit: %.0f bytes/sec\n", defaultRateLimit)
+	}
 	fmt.Printf("For ESP32, set CONFIG_TEST_SERVER_IP=\"%s\"\n", localIP)
 	fmt.Println("==========================================")
 	
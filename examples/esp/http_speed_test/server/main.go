@@ -1,59 +1,38 @@
-// HTTP Speed Test Server
+// HTTP Speed Test / OTA Asset Server
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
-	"strings"
+
+	"embed-zig/examples/esp/http_speed_test/firmhost"
 )
 
 const httpPort = 8080
 
 func main() {
-	http.HandleFunc("/test/", handleTest)
-	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, `{"server":"HTTP Speed Test","port":%d}`, httpPort)
+	root := flag.String("ota-root", "ota", "Directory firmware assets are served from (<target>/<version>/firmware.bin)")
+	throttleKbps := flag.Int("throttle-kbps", 0, "Cap outbound bandwidth per connection (0 = unlimited)")
+	injectDrop := flag.Int("inject-drop", 0, "Percent chance (0-100) of dropping a streaming response mid-transfer")
+	flag.Parse()
+
+	srv := firmhost.New(firmhost.Config{
+		Root:              *root,
+		ThrottleKbps:      *throttleKbps,
+		InjectDropPercent: *injectDrop,
 	})
 
-	fmt.Println("HTTP Speed Test Server")
-	fmt.Printf("Listening on :%d\n", httpPort)
-	fmt.Println("Endpoints: /test/10m, /test/50m, /test/<bytes>")
+	mux := http.NewServeMux()
+	mux.Handle("/", srv.Handler())
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"server":"HTTP Speed Test / OTA Asset Server","port":%d}`, httpPort)
+	})
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil))
-}
+	fmt.Println("HTTP Speed Test / OTA Asset Server")
+	fmt.Printf("Listening on :%d\n", httpPort)
+	fmt.Println("Endpoints: /test/10m, /test/50m, /test/<bytes>, /ota/<target>/latest, /ota/<target>/<version>/firmware.bin")
 
-func handleTest(w http.ResponseWriter, r *http.Request) {
-	sizeStr := strings.TrimPrefix(r.URL.Path, "/test/")
-	
-	var size int
-	switch sizeStr {
-	case "10m":
-		size = 10 * 1024 * 1024
-	case "50m":
-		size = 50 * 1024 * 1024
-	default:
-		var err error
-		size, err = strconv.Atoi(sizeStr)
-		if err != nil || size > 100*1024*1024 {
-			http.Error(w, "Invalid size", http.StatusBadRequest)
-			return
-		}
-	}
-
-	log.Printf("[%s] GET /test/%s", r.RemoteAddr, sizeStr)
-
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.Itoa(size))
-
-	chunk := make([]byte, 16*1024)
-	for size > 0 {
-		n := len(chunk)
-		if size < n {
-			n = size
-		}
-		w.Write(chunk[:n])
-		size -= n
-	}
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpPort), mux))
 }
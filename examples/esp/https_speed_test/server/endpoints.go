@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverStart is the reference point handlePing's recv_mono_ns is measured
+// from, since Go doesn't expose the runtime's raw monotonic clock reading.
+// A client comparing the delta between two /ping calls against its own
+// elapsed time still gets a wall-clock-skew-free one-way-delay estimate.
+var serverStart = time.Now()
+
+// handleUpload drains a POST body while counting bytes, for measuring
+// uplink goodput the way handleTest measures downlink.
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		log.Printf("[%s] POST /upload: read error after %d bytes: %v", r.RemoteAddr, n, err)
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+	if s := statsFromContext(r.Context()); s != nil {
+		s.bytesIn.Add(n)
+	}
+
+	dur := time.Since(start)
+	var mbps float64
+	if dur > 0 {
+		mbps = float64(n) * 8 / dur.Seconds() / 1e6
+	}
+	log.Printf("[%s] POST /upload: %d bytes in %s (%.2f Mbps)", r.RemoteAddr, n, dur, mbps)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"bytes":%d,"duration_ms":%d,"mbps":%.3f}`, n, dur.Milliseconds(), mbps)
+}
+
+// handlePing reports the server's receive instant, both as a wall-clock
+// Unix timestamp and as nanos elapsed since serverStart, so a client can
+// estimate one-way delay (via recv_mono_ns deltas) independent of clock
+// skew between client and server.
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"recv_unix_ns":%d,"recv_mono_ns":%d}`, now.UnixNano(), now.Sub(serverStart).Nanoseconds())
+}
+
+// xorshift32 advances a 32-bit xorshift generator one step. x must be
+// nonzero or the generator is a fixed point at zero forever.
+func xorshift32(x uint32) uint32 {
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	return x
+}
+
+// handlePattern streams size bytes of a deterministic xorshift32 sequence
+// seeded by ?seed=, so firmware can verify received payload bytes match
+// what was sent rather than just counting them.
+func handlePattern(w http.ResponseWriter, r *http.Request) {
+	sizeStr := strings.TrimPrefix(r.URL.Path, "/pattern/")
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size < 0 || size > 100*1024*1024 {
+		http.Error(w, "Invalid size", http.StatusBadRequest)
+		return
+	}
+
+	seed := uint32(1)
+	if q := r.URL.Query().Get("seed"); q != "" {
+		parsed, err := strconv.ParseUint(q, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid seed", http.StatusBadRequest)
+			return
+		}
+		seed = uint32(parsed)
+	}
+	if seed == 0 {
+		seed = 1
+	}
+
+	log.Printf("[%s] GET /pattern/%s?seed=%d", r.RemoteAddr, sizeStr, seed)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+
+	tw := newTrackingWriter(w, r)
+	state := seed
+	var word [4]byte
+	chunk := make([]byte, 4*1024)
+	for remaining := size; remaining > 0; {
+		n := len(chunk)
+		if remaining < n {
+			n = remaining
+		}
+		for i := 0; i < n; i += 4 {
+			state = xorshift32(state)
+			binary.LittleEndian.PutUint32(word[:], state)
+			copy(chunk[i:], word[:])
+		}
+		if _, err := tw.Write(chunk[:n]); err != nil {
+			log.Printf("[%s] Write error: %v", r.RemoteAddr, err)
+			return
+		}
+		remaining -= n
+		tw.Flush()
+	}
+}
@@ -1,20 +1,73 @@
 // HTTPS Speed Test Server with TLS 1.2 (ESP32 compatible)
+//
+// Flags: -rate-limit, -client-ca, -client-ca-dir, -require-client-cert,
+// -pin-sha256, -reload-interval, -san
+//
+// The server certificate and -client-ca-dir pool are polled for changes
+// every -reload-interval and hot-swapped without a restart, so a soak test
+// can run through a short-lived cert's renewal. If certs/server.crt is
+// absent, a self-signed dev certificate is generated (or reloaded from
+// cache) via lib/pkg/devcerts instead.
+//
+// Beyond the one-way /test download, /upload measures uplink goodput,
+// /ping supports one-way-delay estimation, /pattern verifies payload
+// integrity against a deterministic byte stream, and /metrics exposes
+// per-connection counters (bytes, first-byte latency, and a TCP
+// retransmit counter that stays 0 because TCP_INFO is not read on any
+// platform) in Prometheus text format.
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"embed-zig/lib/pkg/devcerts"
+	"embed-zig/lib/pkg/ratelimit"
 )
 
 const httpsPort = 8443
 
+// defaultRateLimit is the -rate-limit flag value, in bytes/sec (0 =
+// unlimited). A request's own ?rate= query parameter overrides it.
+var defaultRateLimit float64
+
 func main() {
+	rateLimit := flag.String("rate-limit", "", "Cap outbound bandwidth per request (bytes/sec, e.g. 256k); empty = unlimited")
+	clientCA := flag.String("client-ca", "", "PEM file of a CA to verify client certificates against; enables mTLS")
+	clientCADir := flag.String("client-ca-dir", "", "Directory of client CA PEM files, re-scanned every -reload-interval; enables mTLS")
+	requireClientCert := flag.Bool("require-client-cert", false, "Require and verify a client certificate (implied by -client-ca/-client-ca-dir)")
+	pinSHA256 := flag.String("pin-sha256", "", "Hex SHA-256 of the expected client leaf cert SPKI; reject any other client cert")
+	reloadInterval := flag.Duration("reload-interval", 5*time.Second, "How often to poll certs/ and -client-ca-dir for changes")
+	san := flag.String("san", "", "Comma-separated extra SANs (DNS names or IPs) for the auto-generated dev certificate, used when certs/server.crt is absent")
+	flag.Parse()
+
+	rate, err := ratelimit.ParseRate(*rateLimit)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defaultRateLimit = rate
+
+	var clientCAs *x509.CertPool
+	if *clientCA != "" {
+		pool, err := loadCertPool(*clientCA)
+		if err != nil {
+			log.Fatalf("Failed to load -client-ca: %v", err)
+		}
+		clientCAs = pool
+	}
+
 	// Find certificates
 	execPath, _ := os.Executable()
 	execDir := filepath.Dir(execPath)
@@ -28,21 +81,64 @@ func main() {
 	}
 
 	fmt.Println("HTTPS Speed Test Server (TLS 1.2)")
-	fmt.Printf("Cert: %s\n", certFile)
-	fmt.Printf("Key:  %s\n", keyFile)
+
+	var certs *certCache
+	if _, err := os.Stat(certFile); os.IsNotExist(err) {
+		var extraSANs []string
+		if *san != "" {
+			extraSANs = strings.Split(*san, ",")
+		}
+		pair, err := devcerts.LoadOrGenerate(extraSANs)
+		if err != nil {
+			log.Fatalf("Failed to generate dev certificate: %v", err)
+		}
+		fmt.Println("No certs/server.crt found; using a cached self-signed dev certificate")
+		fmt.Printf("CA fingerprint: %s\n", pair.CAFingerprint)
+		fmt.Println(devcerts.ConfigSnippet(pair.CACertPEM))
+		certs = newStaticCertCache(pair.Cert)
+	} else {
+		fmt.Printf("Cert: %s\n", certFile)
+		fmt.Printf("Key:  %s\n", keyFile)
+
+		certs, err = newCertCache(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		go watchFiles([]string{certFile, keyFile}, *reloadInterval, func() error {
+			return certs.reload(certFile, keyFile)
+		}, "TLS certificate")
+	}
+
+	var caStore *clientCADirStore
+	if *clientCADir != "" {
+		caStore, err = newClientCADirStore(*clientCADir)
+		if err != nil {
+			log.Fatalf("Failed to load -client-ca-dir: %v", err)
+		}
+		// The directory itself is watched too: adding or removing a file
+		// changes its mtime, which triggers a re-scan.
+		go watchFiles(append(dirFiles(*clientCADir), *clientCADir), *reloadInterval, func() error {
+			return caStore.reload(*clientCADir)
+		}, "client CA pool")
+	}
 
 	http.HandleFunc("/test/", handleTest)
+	http.HandleFunc("/upload", handleUpload)
+	http.HandleFunc("/ping", handlePing)
+	http.HandleFunc("/pattern/", handlePattern)
+	http.HandleFunc("/metrics", handleMetrics)
 	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, `{"server":"HTTPS Speed Test","port":%d}`, httpsPort)
 	})
 
 	fmt.Printf("Listening on :%d\n", httpsPort)
-	fmt.Println("Endpoints: /test/10m, /test/50m, /test/<bytes>")
+	fmt.Println("Endpoints: /test/10m, /test/50m, /test/<bytes> (?rate=256k), /upload (POST), /ping, /pattern/<bytes> (?seed=1234), /metrics")
 
 	// TLS 1.2 config for ESP32 compatibility
 	tlsCfg := &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		MaxVersion: tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		MaxVersion:     tls.VersionTLS12,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
@@ -52,14 +148,43 @@ func main() {
 			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
 		},
 	}
+	switch {
+	case caStore != nil:
+		// ClientCAs can't be swapped in place mid-run, so the current pool
+		// is handed to each new handshake through GetConfigForClient instead
+		// (the same knob RequireSNI uses in the TLS test server).
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsCfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := tlsCfg.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = caStore.Pool()
+			return cfg, nil
+		}
+	case clientCAs != nil || *requireClientCert:
+		tlsCfg.ClientCAs = clientCAs
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case *pinSHA256 != "":
+		// -pin-sha256 alone (no -client-ca/-client-ca-dir) still needs a
+		// client cert requested, or crypto/tls never calls
+		// VerifyPeerCertificate and any client — cert or none — connects.
+		// RequireAnyClientCert requires one without also demanding a CA
+		// chain, since pinning is meant to stand in for that.
+		tlsCfg.ClientAuth = tls.RequireAnyClientCert
+	}
+	if *pinSHA256 != "" {
+		tlsCfg.VerifyPeerCertificate = pinVerifier(*pinSHA256)
+	}
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", httpsPort),
 		TLSConfig:    tlsCfg,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)), // Disable HTTP/2
 	}
+	trackConns(server)
 
-	log.Fatal(server.ListenAndServeTLS(certFile, keyFile))
+	// certFile/keyFile are empty so ListenAndServeTLS relies entirely on
+	// tlsCfg.GetCertificate, which is what makes hot-reload possible.
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
 
 func handleTest(w http.ResponseWriter, r *http.Request) {
@@ -80,28 +205,94 @@ func handleTest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	log.Printf("[%s] GET /test/%s", r.RemoteAddr, sizeStr)
+	rate := defaultRateLimit
+	if q := r.URL.Query().Get("rate"); q != "" {
+		parsed, err := ratelimit.ParseRate(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rate = parsed
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		log.Printf("[%s] GET /test/%s (client cert fingerprint %s)", r.RemoteAddr, sizeStr, certFingerprint(r.TLS.PeerCertificates[0]))
+	} else {
+		log.Printf("[%s] GET /test/%s", r.RemoteAddr, sizeStr)
+	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.Itoa(size))
 
 	// Send in 4KB chunks for ESP32 TLS compatibility
 	chunk := make([]byte, 4*1024)
-	flusher, canFlush := w.(http.Flusher)
+	tw := newTrackingWriter(w, r)
+
+	var out io.Writer = tw
+	if rate > 0 {
+		out = ratelimit.NewWriter(tw, rate)
+	}
 
 	for size > 0 {
 		n := len(chunk)
 		if size < n {
 			n = size
 		}
-		_, err := w.Write(chunk[:n])
+		_, err := out.Write(chunk[:n])
 		if err != nil {
 			log.Printf("[%s] Write error: %v", r.RemoteAddr, err)
 			return
 		}
 		size -= n
-		if canFlush {
-			flusher.Flush()
+		tw.Flush()
+	}
+}
+
+// loadCertPool reads a PEM file of one or more CA certificates into a pool,
+// for verifying client certificates under -client-ca.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// pinVerifier returns a tls.Config.VerifyPeerCertificate callback that
+// rejects the handshake unless the peer leaf certificate's SPKI SHA-256
+// matches wantHex, letting -pin-sha256 regression-test certificate pinning
+// logic independent of which CA issued the cert.
+func pinVerifier(wantHex string) func([][]byte, [][]*x509.Certificate) error {
+	want := strings.ToLower(wantHex)
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("pin-sha256: no peer certificate presented")
 		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("pin-sha256: parse leaf certificate: %w", err)
+		}
+		sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		got := hex.EncodeToString(sum[:])
+		if got != want {
+			return fmt.Errorf("pin-sha256: peer SPKI %s does not match pinned %s", got, want)
+		}
+		return nil
+	}
+}
+
+// certFingerprint renders a device-ID-style fingerprint for cert: the first
+// 8 bytes of its SHA-256 digest as colon-separated hex pairs, e.g.
+// "7c:df:a1:00:11:22:33:44".
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		parts[i] = fmt.Sprintf("%02x", sum[i])
 	}
+	return strings.Join(parts, ":")
 }
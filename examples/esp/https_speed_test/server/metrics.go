@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connStats accumulates per-connection counters between the
+// http.Server.ConnState hook that creates them and the handlers (via
+// statsFromContext) that update them, and is folded into globalStats once
+// the connection closes.
+type connStats struct {
+	conn        net.Conn
+	acceptedAt  time.Time
+	firstByteAt atomic.Int64 // unix nanos of the first response byte written, 0 until set
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+}
+
+// statsCtxKey is the ConnContext key a *connStats is stored under.
+type statsCtxKey struct{}
+
+// statsFromContext returns the current connection's *connStats, or nil if
+// the request didn't arrive through trackConns (e.g. in a test harness).
+func statsFromContext(ctx context.Context) *connStats {
+	s, _ := ctx.Value(statsCtxKey{}).(*connStats)
+	return s
+}
+
+// noteFirstByte records the time of the first response byte on s, once.
+func (s *connStats) noteFirstByte() {
+	if s == nil {
+		return
+	}
+	s.firstByteAt.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// globalStats are the cumulative counters /metrics exposes, aggregated
+// from every connStats as its connection closes.
+type globalStats struct {
+	bytesIn               atomic.Int64
+	bytesOut              atomic.Int64
+	connectionsTotal      atomic.Int64
+	firstByteLatencyCount atomic.Int64
+	firstByteLatencySumNs atomic.Int64
+	retransmitsTotal      atomic.Int64
+}
+
+var stats globalStats
+
+// finish folds s into the global totals when its connection closes.
+func (s *connStats) finish() {
+	stats.bytesIn.Add(s.bytesIn.Load())
+	stats.bytesOut.Add(s.bytesOut.Load())
+	if first := s.firstByteAt.Load(); first != 0 {
+		stats.firstByteLatencyCount.Add(1)
+		stats.firstByteLatencySumNs.Add(first - s.acceptedAt.UnixNano())
+	}
+	if n, ok := tcpRetransmits(s.conn); ok {
+		stats.retransmitsTotal.Add(int64(n))
+	}
+}
+
+// trackConns wires a *connStats into every connection's request context,
+// so handlers can report bytes in/out and the first-byte timestamp, and
+// rolls each connection's stats into the global totals when it closes.
+func trackConns(server *http.Server) {
+	var mu sync.Mutex
+	byConn := make(map[net.Conn]*connStats)
+
+	server.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			mu.Lock()
+			byConn[c] = &connStats{conn: c, acceptedAt: time.Now()}
+			mu.Unlock()
+			stats.connectionsTotal.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			mu.Lock()
+			s, ok := byConn[c]
+			delete(byConn, c)
+			mu.Unlock()
+			if ok {
+				s.finish()
+			}
+		}
+	}
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		mu.Lock()
+		s := byConn[c]
+		mu.Unlock()
+		if s == nil {
+			return ctx
+		}
+		return context.WithValue(ctx, statsCtxKey{}, s)
+	}
+}
+
+// trackingWriter wraps a ResponseWriter so handleTest/handlePattern can
+// report bytesOut and the first-byte timestamp without threading a
+// *connStats through every Write call by hand.
+type trackingWriter struct {
+	http.ResponseWriter
+	stats *connStats
+}
+
+func newTrackingWriter(w http.ResponseWriter, r *http.Request) *trackingWriter {
+	return &trackingWriter{ResponseWriter: w, stats: statsFromContext(r.Context())}
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	w.stats.noteFirstByte()
+	n, err := w.ResponseWriter.Write(p)
+	if w.stats != nil {
+		w.stats.bytesOut.Add(int64(n))
+	}
+	return n, err
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// handleMetrics renders the cumulative counters in Prometheus text
+// exposition format, turning the speed-test server into an RTT/goodput/
+// loss harness rather than just a one-way downloader.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP speedtest_connections_total TCP connections accepted.")
+	fmt.Fprintln(w, "# TYPE speedtest_connections_total counter")
+	fmt.Fprintf(w, "speedtest_connections_total %d\n", stats.connectionsTotal.Load())
+
+	fmt.Fprintln(w, "# HELP speedtest_bytes_in_total Bytes read from request bodies (e.g. /upload).")
+	fmt.Fprintln(w, "# TYPE speedtest_bytes_in_total counter")
+	fmt.Fprintf(w, "speedtest_bytes_in_total %d\n", stats.bytesIn.Load())
+
+	fmt.Fprintln(w, "# HELP speedtest_bytes_out_total Bytes written in responses (e.g. /test, /pattern).")
+	fmt.Fprintln(w, "# TYPE speedtest_bytes_out_total counter")
+	fmt.Fprintf(w, "speedtest_bytes_out_total %d\n", stats.bytesOut.Load())
+
+	fmt.Fprintln(w, "# HELP speedtest_first_byte_latency_seconds Time from TCP accept to the first response byte.")
+	fmt.Fprintln(w, "# TYPE speedtest_first_byte_latency_seconds summary")
+	fmt.Fprintf(w, "speedtest_first_byte_latency_seconds_sum %f\n", time.Duration(stats.firstByteLatencySumNs.Load()).Seconds())
+	fmt.Fprintf(w, "speedtest_first_byte_latency_seconds_count %d\n", stats.firstByteLatencyCount.Load())
+
+	fmt.Fprintln(w, "# HELP speedtest_tcp_retransmits_total TCP_INFO retransmits observed on closed connections (always 0: TCP_INFO is not read on any platform).")
+	fmt.Fprintln(w, "# TYPE speedtest_tcp_retransmits_total counter")
+	fmt.Fprintf(w, "speedtest_tcp_retransmits_total %d\n", stats.retransmitsTotal.Load())
+}
+
+// underlyingTCPConn unwraps a *tls.Conn to the raw *net.TCPConn TCP_INFO
+// needs, or reports ok=false for anything else.
+func underlyingTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	return tcpConn, ok
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// certCache holds the server's active TLS certificate behind an
+// atomic.Value so GetCertificate never blocks on a reload in progress and
+// in-flight connections keep using whatever *tls.Certificate they already
+// negotiated with.
+type certCache struct {
+	current atomic.Value // *tls.Certificate
+}
+
+// newCertCache loads certFile/keyFile into a fresh certCache.
+func newCertCache(certFile, keyFile string) (*certCache, error) {
+	c := &certCache{}
+	if err := c.reload(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload re-reads and validates certFile/keyFile and swaps them in for new
+// handshakes. On error the previously loaded certificate is left in place.
+func (c *certCache) reload(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	if _, err := x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("parse leaf certificate: %w", err)
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certCache) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load().(*tls.Certificate), nil
+}
+
+// newStaticCertCache wraps a single already-loaded certificate in a
+// certCache, for the auto-generated dev certificate path where there's no
+// certs/server.crt on disk for watchFiles to poll.
+func newStaticCertCache(cert tls.Certificate) *certCache {
+	c := &certCache{}
+	c.current.Store(&cert)
+	return c
+}
+
+// clientCADirStore holds the client-CA pool built from -client-ca-dir
+// behind an atomic.Value, the same swap-in-place pattern certCache uses,
+// since an in-use x509.CertPool can't be mutated after tls.Config captures
+// it.
+type clientCADirStore struct {
+	pool atomic.Value // *x509.CertPool
+}
+
+// newClientCADirStore builds the initial pool from every PEM file in dir.
+func newClientCADirStore(dir string) (*clientCADirStore, error) {
+	s := &clientCADirStore{}
+	if err := s.reload(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload re-scans dir and rebuilds the pool. On error (including an empty
+// or unreadable directory) the previously loaded pool is left in place.
+func (s *clientCADirStore) reload(dir string) error {
+	files := dirFiles(dir)
+	pool := x509.NewCertPool()
+	loaded := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", f, err)
+		}
+		if pool.AppendCertsFromPEM(data) {
+			loaded++
+		}
+	}
+	if loaded == 0 {
+		return fmt.Errorf("no client CA certificates found in %s", dir)
+	}
+	s.pool.Store(pool)
+	return nil
+}
+
+// Pool returns the currently active client-CA pool.
+func (s *clientCADirStore) Pool() *x509.CertPool {
+	return s.pool.Load().(*x509.CertPool)
+}
+
+// dirFiles lists the regular files directly inside dir, for both the
+// initial clientCADirStore load and the mtime set watchFiles polls. A
+// missing or unreadable dir yields an empty list rather than an error;
+// clientCADirStore.reload then fails because it finds no CA certificates.
+func dirFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files
+}
+
+// watchFiles polls the mtimes of paths every interval and calls reload
+// whenever any of them changed since the last tick, logging the outcome.
+// A failing reload just stays on the previously loaded material instead of
+// tearing down the listener. This is a plain mtime-poll fallback in lieu of
+// an fsnotify dependency this tree doesn't vendor.
+func watchFiles(paths []string, interval time.Duration, reload func() error, label string) {
+	mtimes := make(map[string]time.Time, len(paths))
+	statAll := func() bool {
+		changed := false
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			if mt, ok := mtimes[p]; !ok || info.ModTime().After(mt) {
+				mtimes[p] = info.ModTime()
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	statAll() // seed mtimes without triggering a reload on startup
+	for range time.Tick(interval) {
+		if !statAll() {
+			continue
+		}
+		if err := reload(); err != nil {
+			log.Printf("%s: reload failed, keeping previous: %v", label, err)
+			continue
+		}
+		log.Printf("%s: reloaded", label)
+	}
+}
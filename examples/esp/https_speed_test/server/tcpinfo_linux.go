@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "net"
+
+// tcpRetransmits is meant to return the kernel's TCP_INFO retransmit counter
+// for the connection's underlying *net.TCPConn via a TCP_INFO getsockopt,
+// but the standard library's syscall package doesn't expose that ioctl on
+// any platform (only the vendored golang.org/x/sys/unix does, which isn't a
+// dependency of this module). Until that dependency is pulled in, report
+// unavailable rather than fail the build.
+func tcpRetransmits(conn net.Conn) (retransmits uint32, ok bool) {
+	return 0, false
+}
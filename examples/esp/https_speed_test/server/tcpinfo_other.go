@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// tcpRetransmits always reports unavailable, as the Linux build in
+// tcpinfo_linux.go does too, so platforms like macOS just don't get a
+// retransmit count in /metrics.
+func tcpRetransmits(conn net.Conn) (retransmits uint32, ok bool) {
+	return 0, false
+}
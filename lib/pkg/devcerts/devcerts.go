@@ -0,0 +1,272 @@
+// Package devcerts generates and caches a self-signed CA and server leaf
+// certificate for local dev/test servers that aren't handed a real
+// certificate, such as the HTTPS speed-test server and the echo server.
+// Generated material is keyed by its SAN set and persisted under
+// $XDG_CACHE_HOME/embed-zig/certs/ (via os.UserCacheDir) so repeat runs
+// against the same SAN set reuse the same CA instead of forcing a reflash
+// of an ESP32 that already trusts it.
+package devcerts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pair is a self-signed CA and the server leaf certificate it issued.
+type Pair struct {
+	Cert          tls.Certificate
+	CACertPEM     []byte
+	CAFingerprint string // hex SHA-256 of the CA cert, colon-separated
+}
+
+// LoadOrGenerate returns a CA+leaf pair covering "localhost", every local
+// IPv4 address net.InterfaceAddrs reports, and extraSANs. The first call
+// for a given SAN set generates a fresh P-256 CA and leaf and caches them
+// on disk; later calls with the same set reload the cached pair instead
+// of minting a new CA.
+func LoadOrGenerate(extraSANs []string) (*Pair, error) {
+	sans, err := sanSet(extraSANs)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := cacheDir(sans)
+	if err != nil {
+		return nil, err
+	}
+	paths := cacheFiles(dir)
+
+	if pair, err := load(paths); err == nil {
+		return pair, nil
+	}
+
+	pair, err := generate(sans)
+	if err != nil {
+		return nil, err
+	}
+	if err := save(dir, paths, pair); err != nil {
+		// A cache we can't write to isn't fatal, just means next run
+		// regenerates; the server can still use the pair we just made.
+		fmt.Fprintf(os.Stderr, "devcerts: failed to cache generated cert: %v\n", err)
+	}
+	return pair, nil
+}
+
+// sanSet builds the deduped, sorted list of SANs a Pair should cover:
+// "localhost", every local IPv4 address, and extra.
+func sanSet(extra []string) ([]string, error) {
+	set := map[string]bool{"localhost": true}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("discover local addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		set[ipnet.IP.String()] = true
+	}
+	for _, s := range extra {
+		if s != "" {
+			set[s] = true
+		}
+	}
+
+	sans := make([]string, 0, len(set))
+	for s := range set {
+		sans = append(sans, s)
+	}
+	sort.Strings(sans)
+	return sans, nil
+}
+
+// cacheDir returns the on-disk directory a given SAN set's generated
+// material lives under, keyed by the SAN set's SHA-256 so two servers
+// with different -san flags never share a CA.
+func cacheDir(sans []string) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve cache dir: %w", err)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(sans, "\x00")))
+	key := hex.EncodeToString(sum[:])[:16]
+	return filepath.Join(base, "embed-zig", "certs", key), nil
+}
+
+// cachePaths are the on-disk locations of a cached pair. Only the CA
+// certificate (not its private key) needs to survive a restart: it's
+// reused purely to verify the still-valid cached server leaf.
+type cachePaths struct {
+	caCert, serverCert, serverKey string
+}
+
+func cacheFiles(dir string) cachePaths {
+	return cachePaths{
+		caCert:     filepath.Join(dir, "ca.crt"),
+		serverCert: filepath.Join(dir, "server.crt"),
+		serverKey:  filepath.Join(dir, "server.key"),
+	}
+}
+
+// load reads a previously cached pair, rejecting it once the server leaf
+// has expired so an old cache doesn't wedge a long-running soak test. The
+// CA certificate's own expiry is not checked.
+func load(paths cachePaths) (*Pair, error) {
+	caCertPEM, err := os.ReadFile(paths.caCert)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(paths.serverCert, paths.serverKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("cached server cert expired %s", leaf.NotAfter)
+	}
+	return &Pair{
+		Cert:          cert,
+		CACertPEM:     caCertPEM,
+		CAFingerprint: fingerprint(caCertPEM),
+	}, nil
+}
+
+// save persists a generated pair to dir so later runs with the same SAN
+// set can reload it instead of minting a new CA.
+func save(dir string, paths cachePaths, pair *Pair) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	if err := os.WriteFile(paths.caCert, pair.CACertPEM, 0644); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Cert.Certificate[0]})
+	if err := os.WriteFile(paths.serverCert, certPEM, 0644); err != nil {
+		return err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(pair.Cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	return os.WriteFile(paths.serverKey, keyPEM, 0600)
+}
+
+// generate mints a fresh P-256 CA and a leaf certificate it signs,
+// covering sans.
+func generate(sans []string) (*Pair, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"embed-zig Dev CA"},
+			CommonName:   "embed-zig Dev CA",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	caCertDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caCertDER)
+	if err != nil {
+		return nil, err
+	}
+	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertDER})
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Organization: []string{"embed-zig Dev Server"},
+			CommonName:   sans[0],
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, san)
+		}
+	}
+
+	serverCertDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	serverCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCertDER})
+	serverKeyBytes, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	serverKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: serverKeyBytes})
+
+	cert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pair{
+		Cert:          cert,
+		CACertPEM:     caCertPEM,
+		CAFingerprint: fingerprint(caCertPEM),
+	}, nil
+}
+
+// fingerprint renders the SHA-256 of a PEM-encoded certificate's DER bytes
+// as colon-separated hex, e.g. "7c:df:a1:00:...".
+func fingerprint(certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	hexSum := hex.EncodeToString(sum[:])
+	parts := make([]string, len(hexSum)/2)
+	for i := range parts {
+		parts[i] = hexSum[i*2 : i*2+2]
+	}
+	return strings.Join(parts, ":")
+}
+
+// ConfigSnippet renders a ready-to-paste CONFIG_TEST_SERVER_CA_PEM line for
+// sdkconfig.defaults, with embedded newlines escaped the way Kconfig
+// string values expect.
+func ConfigSnippet(caCertPEM []byte) string {
+	lines := strings.Split(strings.TrimRight(string(caCertPEM), "\n"), "\n")
+	return fmt.Sprintf("CONFIG_TEST_SERVER_CA_PEM=\"%s\"", strings.Join(lines, "\\n"))
+}
@@ -0,0 +1,177 @@
+package imgsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	want := Header{
+		LoadAddr:  0x10000,
+		ImageSize: 1234,
+		Flags:     0x1,
+		Version:   Version{Major: 1, Minor: 2, Revision: 3, Build: 4},
+	}
+	buf, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	if len(buf) != HeaderSize {
+		t.Fatalf("MarshalBinary length = %d, want %d", len(buf), HeaderSize)
+	}
+
+	var got Header
+	if err := got.UnmarshalBinary(buf); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got != want {
+		t.Errorf("UnmarshalBinary = %+v, want %+v", got, want)
+	}
+}
+
+func TestHeaderUnmarshalRejectsBadMagic(t *testing.T) {
+	buf := make([]byte, HeaderSize)
+	if err := (&Header{}).UnmarshalBinary(buf); err == nil {
+		t.Error("UnmarshalBinary(zeroed buffer) = nil error, want error")
+	}
+}
+
+func TestSignVerifyECDSAP256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	payload := []byte("firmware payload bytes")
+	signed, err := Sign(payload, SignOptions{
+		LoadAddr: 0x10000,
+		Version:  Version{Major: 1},
+		Alg:      ECDSAP256,
+		Key:      priv,
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	hdr, got, err := Verify(signed, &priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("Verify payload = %q, want %q", got, payload)
+	}
+	if hdr.ImageSize != uint32(len(payload)) {
+		t.Errorf("Verify header.ImageSize = %d, want %d", hdr.ImageSize, len(payload))
+	}
+}
+
+func TestSignVerifyRSA2048(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	payload := []byte("another firmware payload")
+	signed, err := Sign(payload, SignOptions{Alg: RSA2048, Key: priv})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	_, got, err := Verify(signed, &priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("Verify payload = %q, want %q", got, payload)
+	}
+}
+
+func TestVerifyRejectsTamperedPayload(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signed, err := Sign([]byte("original"), SignOptions{Alg: ECDSAP256, Key: priv})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	tampered := append([]byte(nil), signed...)
+	tampered[HeaderSize] ^= 0xFF // flip a byte in the payload
+
+	if _, _, err := Verify(tampered, &priv.PublicKey); err == nil {
+		t.Error("Verify(tampered image) = nil error, want error")
+	}
+}
+
+func TestVerifyRejectsWrongKey(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signed, err := Sign([]byte("payload"), SignOptions{Alg: ECDSAP256, Key: priv})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if _, _, err := Verify(signed, &other.PublicKey); err == nil {
+		t.Error("Verify(wrong key) = nil error, want error")
+	}
+}
+
+func TestLoadKeyPEMRoundTrip(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	dir := t.TempDir()
+	privPath := writePEM(t, dir, "key.pem", "PRIVATE KEY", func() ([]byte, error) {
+		return x509.MarshalPKCS8PrivateKey(priv)
+	})
+	pubPath := writePEM(t, dir, "key.pub", "PUBLIC KEY", func() ([]byte, error) {
+		return x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	})
+
+	signer, err := LoadPrivateKeyPEM(privPath)
+	if err != nil {
+		t.Fatalf("LoadPrivateKeyPEM: %v", err)
+	}
+	pub, err := LoadPublicKeyPEM(pubPath)
+	if err != nil {
+		t.Fatalf("LoadPublicKeyPEM: %v", err)
+	}
+
+	signed, err := Sign([]byte("payload"), SignOptions{Alg: ECDSAP256, Key: signer})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, _, err := Verify(signed, pub); err != nil {
+		t.Errorf("Verify with PEM-loaded keys: %v", err)
+	}
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, marshal func() ([]byte, error)) string {
+	t.Helper()
+	der, err := marshal()
+	if err != nil {
+		t.Fatalf("marshal %s: %v", blockType, err)
+	}
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
@@ -0,0 +1,126 @@
+// Package ratelimit implements a token-bucket bandwidth cap for wrapping an
+// io.Writer or io.Reader, in the style of goproxy's IoBind duplex limiter: a
+// float64 token count is spent on every Write/Read and refilled from elapsed
+// wall-clock time, capped at a burst size, with time.Sleep covering any
+// shortfall. It lets speed-test and echo servers validate ESP32 firmware at
+// a fixed link speed (e.g. simulating 2 Mbps cellular) instead of only at
+// wire speed.
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket is the token-bucket state of one Writer or Reader; NewWriter and
+// NewReader each create their own, so a Writer and a Reader on the same
+// stream are limited independently.
+type bucket struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+}
+
+func newBucket(bytesPerSec float64) *bucket {
+	return &bucket{
+		bytesPerSec: bytesPerSec,
+		burst:       bytesPerSec,
+		tokens:      bytesPerSec,
+		last:        time.Now(),
+	}
+}
+
+// take refills tokens for elapsed time, spends n of them, and sleeps off
+// whatever the spend drove negative.
+func (b *bucket) take(n int) {
+	if b.bytesPerSec <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.bytesPerSec
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens < 0 {
+		time.Sleep(time.Duration(-b.tokens / b.bytesPerSec * float64(time.Second)))
+		b.tokens = 0
+	}
+}
+
+// Writer wraps an io.Writer so that sustained writes average no more than
+// bytesPerSec bytes per second.
+type Writer struct {
+	w io.Writer
+	b *bucket
+}
+
+// NewWriter wraps w with a token-bucket limiter capped at bytesPerSec bytes
+// per second and a one-second burst. bytesPerSec <= 0 disables limiting.
+func NewWriter(w io.Writer, bytesPerSec float64) *Writer {
+	return &Writer{w: w, b: newBucket(bytesPerSec)}
+}
+
+// Write blocks as needed to stay under the configured rate, then writes p to
+// the wrapped io.Writer.
+func (rw *Writer) Write(p []byte) (int, error) {
+	rw.b.take(len(p))
+	return rw.w.Write(p)
+}
+
+// Reader wraps an io.Reader so that sustained reads average no more than
+// bytesPerSec bytes per second.
+type Reader struct {
+	r io.Reader
+	b *bucket
+}
+
+// NewReader wraps r with a token-bucket limiter capped at bytesPerSec bytes
+// per second and a one-second burst. bytesPerSec <= 0 disables limiting.
+func NewReader(r io.Reader, bytesPerSec float64) *Reader {
+	return &Reader{r: r, b: newBucket(bytesPerSec)}
+}
+
+// Read reads from the wrapped io.Reader, then blocks as needed so the
+// running average stays under the configured rate.
+func (rr *Reader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		rr.b.take(n)
+	}
+	return n, err
+}
+
+// ParseRate parses a bandwidth string such as "256k" or "2m" (bytes/sec,
+// with an optional K/M suffix) into bytes per second, for flags and
+// query parameters that let a caller pick their own simulated link speed.
+// An empty string returns 0 (unlimited).
+func ParseRate(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := 1.0
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		mult = 1024
+		s = s[:len(s)-1]
+	case 'm', 'M':
+		mult = 1024 * 1024
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ratelimit: invalid rate %q: %w", s, err)
+	}
+	return v * mult, nil
+}
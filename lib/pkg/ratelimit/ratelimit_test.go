@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterThrottles(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1024) // 1KB/s, 1KB burst
+
+	payload := bytes.Repeat([]byte("x"), 2048) // 2x burst, forces one sleep
+
+	start := time.Now()
+	n, err := w.Write(payload)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if n != len(payload) {
+		t.Fatalf("Write = %d bytes, want %d", n, len(payload))
+	}
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("Write returned after %v, want it throttled to roughly 1s for 2KB at 1KB/s", elapsed)
+	}
+	if buf.Len() != len(payload) {
+		t.Errorf("buf.Len() = %d, want %d", buf.Len(), len(payload))
+	}
+}
+
+func TestWriterUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 0)
+
+	start := time.Now()
+	if _, err := w.Write(bytes.Repeat([]byte("y"), 1<<20)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("unlimited Write took %v, want effectively instant", elapsed)
+	}
+}
+
+func TestReaderThrottles(t *testing.T) {
+	r := NewReader(strings.NewReader(strings.Repeat("z", 2048)), 1024)
+
+	start := time.Now()
+	buf := make([]byte, 2048)
+	total := 0
+	for total < len(buf) {
+		n, err := r.Read(buf[total:])
+		total += n
+		if err != nil {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+	if total != 2048 {
+		t.Fatalf("read %d bytes, want 2048", total)
+	}
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("Read returned after %v, want it throttled to roughly 1s for 2KB at 1KB/s", elapsed)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+	}{
+		{"", 0},
+		{"512", 512},
+		{"256k", 256 * 1024},
+		{"2m", 2 * 1024 * 1024},
+		{"1M", 1024 * 1024},
+	}
+	for _, c := range cases {
+		got, err := ParseRate(c.in)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseRateInvalid(t *testing.T) {
+	if _, err := ParseRate("not-a-rate"); err == nil {
+		t.Error("ParseRate(invalid) = nil error, want error")
+	}
+}
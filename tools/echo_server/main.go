@@ -4,46 +4,70 @@
 // - TCP echo on port 8080: receives data and echoes back immediately
 // - TLS echo on port 8443: same but with TLS encryption
 //
+// The TLS certificate is a self-signed dev certificate from
+// lib/pkg/devcerts, cached on disk so repeat runs reuse the same CA
+// instead of minting a new one every time.
+//
 // Usage:
-//   go run main.go [-tcp-port 8080] [-tls-port 8443]
+//   go run main.go [-tcp-port 8080] [-tls-port 8443] [-rate-limit 256k]
+//                  [-client-ca ca.pem] [-require-client-cert] [-pin-sha256 <hex>]
 
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math/big"
 	"net"
 	"os"
+	"strings"
 	"time"
+
+	"embed-zig/lib/pkg/devcerts"
+	"embed-zig/lib/pkg/ratelimit"
 )
 
 func main() {
 	tcpPort := flag.Int("tcp-port", 8080, "TCP echo server port")
 	tlsPort := flag.Int("tls-port", 8443, "TLS echo server port")
 	caOut := flag.String("ca-out", "", "Write CA cert to file (for client verification)")
+	rateLimit := flag.String("rate-limit", "", "Cap echo bandwidth per connection (bytes/sec, e.g. 256k); empty = unlimited")
+	clientCA := flag.String("client-ca", "", "PEM file of a CA to verify client certificates against; enables mTLS on the TLS listener")
+	requireClientCert := flag.Bool("require-client-cert", false, "Require and verify a client certificate (implied by -client-ca)")
+	pinSHA256 := flag.String("pin-sha256", "", "Hex SHA-256 of the expected client leaf cert SPKI; reject any other client cert")
 	flag.Parse()
 
+	bytesPerSec, err := ratelimit.ParseRate(*rateLimit)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if *clientCA != "" {
+		pool, err := loadCertPool(*clientCA)
+		if err != nil {
+			log.Fatalf("Failed to load -client-ca: %v", err)
+		}
+		clientCAs = pool
+	}
+
 	log.Println("========================================")
 	log.Println("  Echo Server - TCP/TLS Duplex Test")
 	log.Println("========================================")
 
-	// Generate TLS certificates
+	// Generate (or reload the cached) TLS certificates
 	log.Println("\nGenerating certificates...")
-	cert, caCertPEM, err := generateCert()
+	pair, err := devcerts.LoadOrGenerate(nil)
 	if err != nil {
 		log.Fatalf("Failed to generate certificates: %v", err)
 	}
-	log.Println("  Certificates generated")
+	cert, caCertPEM := pair.Cert, pair.CACertPEM
+	log.Printf("  Certificates ready (CA fingerprint %s)", pair.CAFingerprint)
 
 	// Write CA cert if requested
 	if *caOut != "" {
@@ -55,10 +79,10 @@ func main() {
 	}
 
 	// Start TCP echo server
-	go runTCPServer(*tcpPort)
+	go runTCPServer(*tcpPort, bytesPerSec)
 
 	// Start TLS echo server
-	go runTLSServer(*tlsPort, cert)
+	go runTLSServer(*tlsPort, cert, bytesPerSec, clientCAs, *requireClientCert, *pinSHA256)
 
 	// Print local IP addresses
 	log.Println("\n----------------------------------------")
@@ -78,7 +102,7 @@ func main() {
 	select {}
 }
 
-func runTCPServer(port int) {
+func runTCPServer(port int, bytesPerSec float64) {
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -94,16 +118,31 @@ func runTCPServer(port int) {
 			log.Printf("[TCP] Accept error: %v", err)
 			continue
 		}
-		go handleConnection(conn, "TCP")
+		go handleConnection(conn, "TCP", bytesPerSec)
 	}
 }
 
-func runTLSServer(port int, cert tls.Certificate) {
+func runTLSServer(port int, cert tls.Certificate, bytesPerSec float64, clientCAs *x509.CertPool, requireClientCert bool, pinSHA256 string) {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS12,
 	}
 
+	if clientCAs != nil || requireClientCert {
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if pinSHA256 != "" {
+		// -pin-sha256 alone still needs a client cert requested, or
+		// crypto/tls never calls VerifyPeerCertificate and any client —
+		// cert or none — connects. RequireAnyClientCert requires one
+		// without also demanding a CA chain, since pinning stands in for
+		// that here.
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	}
+	if pinSHA256 != "" {
+		tlsConfig.VerifyPeerCertificate = pinVerifier(pinSHA256)
+	}
+
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	listener, err := tls.Listen("tcp", addr, tlsConfig)
 	if err != nil {
@@ -119,19 +158,36 @@ func runTLSServer(port int, cert tls.Certificate) {
 			log.Printf("[TLS] Accept error: %v", err)
 			continue
 		}
-		go handleConnection(conn, "TLS")
+		go handleConnection(conn, "TLS", bytesPerSec)
 	}
 }
 
-func handleConnection(conn net.Conn, protocol string) {
+func handleConnection(conn net.Conn, protocol string, bytesPerSec float64) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[%s] Connected: %s", protocol, remoteAddr)
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("[%s] %s: handshake failed: %v", protocol, remoteAddr, err)
+			return
+		}
+		if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+			log.Printf("[%s] %s: client cert fingerprint %s", protocol, remoteAddr, certFingerprint(peers[0]))
+		}
+	}
+
 	// Set read deadline to detect client disconnect
 	conn.SetDeadline(time.Now().Add(60 * time.Second))
 
+	var r io.Reader = conn
+	var w io.Writer = conn
+	if bytesPerSec > 0 {
+		r = ratelimit.NewReader(conn, bytesPerSec)
+		w = ratelimit.NewWriter(conn, bytesPerSec)
+	}
+
 	buf := make([]byte, 4096)
 	totalBytes := 0
 
@@ -139,7 +195,7 @@ func handleConnection(conn net.Conn, protocol string) {
 		// Reset deadline for each read
 		conn.SetDeadline(time.Now().Add(60 * time.Second))
 
-		n, err := conn.Read(buf)
+		n, err := r.Read(buf)
 		if err != nil {
 			if err == io.EOF {
 				log.Printf("[%s] %s: Connection closed by client (total: %d bytes)", protocol, remoteAddr, totalBytes)
@@ -152,7 +208,7 @@ func handleConnection(conn net.Conn, protocol string) {
 		}
 
 		// Echo back immediately
-		written, err := conn.Write(buf[:n])
+		written, err := w.Write(buf[:n])
 		if err != nil {
 			log.Printf("[%s] %s: Write error: %v", protocol, remoteAddr, err)
 			return
@@ -163,77 +219,51 @@ func handleConnection(conn net.Conn, protocol string) {
 	}
 }
 
-func generateCert() (tls.Certificate, []byte, error) {
-	// Generate CA key
-	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return tls.Certificate{}, nil, err
-	}
-
-	// CA certificate template
-	caTemplate := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"Echo Test CA"},
-			CommonName:   "Echo Test CA",
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		BasicConstraintsValid: true,
-		IsCA:                  true,
-		MaxPathLen:            1,
-	}
-
-	// Create CA certificate
-	caCertDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+// loadCertPool reads a PEM file of one or more CA certificates into a pool,
+// for verifying client certificates under -client-ca.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return tls.Certificate{}, nil, err
+		return nil, err
 	}
-
-	caCert, _ := x509.ParseCertificate(caCertDER)
-	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertDER})
-
-	// Generate server key
-	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return tls.Certificate{}, nil, err
-	}
-
-	// Server certificate template
-	serverTemplate := &x509.Certificate{
-		SerialNumber: big.NewInt(2),
-		Subject: pkix.Name{
-			Organization: []string{"Echo Test Server"},
-			CommonName:   "localhost",
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:    []string{"localhost", "echo.local"},
-		IPAddresses: []net.IP{
-			net.ParseIP("127.0.0.1"),
-			net.ParseIP("::1"),
-			net.ParseIP("0.0.0.0"),
-		},
-	}
-
-	// Create server certificate signed by CA
-	serverCertDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
-	if err != nil {
-		return tls.Certificate{}, nil, err
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
 	}
+	return pool, nil
+}
 
-	serverCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCertDER})
-
-	serverKeyBytes, _ := x509.MarshalECPrivateKey(serverKey)
-	serverKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: serverKeyBytes})
-
-	cert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
-	if err != nil {
-		return tls.Certificate{}, nil, err
+// pinVerifier returns a tls.Config.VerifyPeerCertificate callback that
+// rejects the handshake unless the peer leaf certificate's SPKI SHA-256
+// matches wantHex, letting -pin-sha256 regression-test certificate pinning
+// logic independent of which CA issued the cert.
+func pinVerifier(wantHex string) func([][]byte, [][]*x509.Certificate) error {
+	want := strings.ToLower(wantHex)
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("pin-sha256: no peer certificate presented")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("pin-sha256: parse leaf certificate: %w", err)
+		}
+		sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		got := hex.EncodeToString(sum[:])
+		if got != want {
+			return fmt.Errorf("pin-sha256: peer SPKI %s does not match pinned %s", got, want)
+		}
+		return nil
 	}
+}
 
-	return cert, caCertPEM, nil
+// certFingerprint renders a device-ID-style fingerprint for cert: the first
+// 8 bytes of its SHA-256 digest as colon-separated hex pairs, e.g.
+// "7c:df:a1:00:11:22:33:44".
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		parts[i] = fmt.Sprintf("%02x", sum[i])
+	}
+	return strings.Join(parts, ":")
 }
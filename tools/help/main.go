@@ -12,24 +12,50 @@
 //	bazel run //tools/help -- tools     # Only tools
 //	bazel run //tools/help -- libs      # Only libraries
 //	bazel run //tools/help -- all       # All categories with full detail
+//	bazel run //tools/help -- deps //foo:bar   # Categorized deps(//foo:bar)
+//	bazel run //tools/help -- rdeps //foo:bar  # Categorized rdeps(//..., //foo:bar)
+//	bazel run //tools/help -- graph            # Dependency graph as DOT/Mermaid
+//
+// Every subcommand also accepts:
+//
+//	--format={text,json,ndjson}  # defaults to text
+//	--filter-tag=TAG             # only targets carrying this tag
+//	--filter-lang=LANG           # only targets in this language (e.g. Go)
+//	--package-prefix=//PREFIX    # only targets under this package prefix
+//	--fail-on-empty              # exit 1 if the result set is empty (for CI)
+//
+// graph additionally accepts:
+//
+//	--kind={dot,mermaid}   # output format, defaults to dot
+//	--scope=//path/...     # target pattern to graph, defaults to //...
+//	--edges={deps,rdeps,both}  # edge direction, defaults to deps
+//	--focus=//foo:bar      # restrict to the transitive closure around this label
+//	-o path.dot            # write output to a file instead of stdout
 package main
 
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
 
-// target holds a Bazel target label and its inferred language.
+// target holds a Bazel target label and the metadata filters and output
+// modes need: its rule kind, inferred language, tags, and package.
 type target struct {
 	label string
+	kind  string // e.g. "go_binary", "zig_test"
 	lang  string // e.g. "Zig", "Go", "C/C++", "Shell", ""
+	tags  []string
+	pkg   string // the label's package, e.g. "//tools/help"
 }
 
 // category groups targets of the same kind.
@@ -40,12 +66,107 @@ type category struct {
 	targets []target
 }
 
+// categoryMeta is the fixed icon/title/hint for each of the five buckets
+// both collectAll and categorize (used by deps/rdeps) sort targets into.
+var categoryMeta = []struct {
+	title string
+	icon  string
+	hint  string
+}{
+	{"Apps", "📦", "bazel run"},
+	{"Tests", "🧪", "bazel test"},
+	{"E2E Tests", "🔌", "bazel test --test_tag_filters=e2e"},
+	{"Tools", "🔧", "bazel run"},
+	{"Libraries", "📋", ""},
+}
+
+// targetFilter is the set of --filter-tag/--filter-lang/--package-prefix
+// constraints applied uniformly across every category and query mode.
+type targetFilter struct {
+	tag           string
+	lang          string
+	packagePrefix string
+}
+
+func (f targetFilter) empty() bool {
+	return f.tag == "" && f.lang == "" && f.packagePrefix == ""
+}
+
+func (f targetFilter) matches(t target) bool {
+	if f.tag != "" {
+		found := false
+		for _, tag := range t.tags {
+			if tag == f.tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.lang != "" && !strings.EqualFold(t.lang, f.lang) {
+		return false
+	}
+	if f.packagePrefix != "" && !strings.HasPrefix(t.pkg, f.packagePrefix) {
+		return false
+	}
+	return true
+}
+
 func main() {
+	// The subcommand, if any, is always the first non-flag argument; flags
+	// may appear before or after it.
+	args := os.Args[1:]
 	sub := ""
-	if len(os.Args) > 1 {
-		sub = os.Args[1]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub = args[0]
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("help", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text, json, or ndjson")
+	filterTag := fs.String("filter-tag", "", "Only include targets carrying this tag")
+	filterLang := fs.String("filter-lang", "", "Only include targets in this language (e.g. Go, Zig)")
+	packagePrefix := fs.String("package-prefix", "", "Only include targets whose package starts with this prefix")
+	failOnEmpty := fs.Bool("fail-on-empty", false, "Exit 1 if the result set is empty")
+	graphKind := fs.String("kind", "dot", "graph subcommand: output kind, dot or mermaid")
+	scope := fs.String("scope", "//...", "graph subcommand: target pattern to graph")
+	edgesMode := fs.String("edges", "deps", "graph subcommand: edge direction, deps, rdeps, or both")
+	focus := fs.String("focus", "", "graph subcommand: restrict to the transitive closure around this label")
+	outPath := fs.String("o", "", "graph subcommand: write output to this file instead of stdout")
+	fs.Parse(args)
+	// fs.Parse stops at the first non-flag argument: pick up a subcommand
+	// that follows leading flags, then a deps/rdeps label, parsing the flags
+	// after each.
+	if sub == "" && fs.NArg() > 0 {
+		sub = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	label := ""
+	if (sub == "deps" || sub == "rdeps") && fs.NArg() > 0 {
+		label = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
 	}
 
+	filter := targetFilter{tag: *filterTag, lang: *filterLang, packagePrefix: *packagePrefix}
+
+	switch sub {
+	case "deps", "rdeps":
+		if label == "" {
+			fmt.Fprintf(os.Stderr, "Usage: bazel run //tools/help -- %s <label> [flags]\n", sub)
+			os.Exit(1)
+		}
+		runQuery(sub, label, *format, filter, *failOnEmpty)
+	case "graph":
+		runGraph(*graphKind, *scope, *edgesMode, *focus, *outPath, filter, *failOnEmpty)
+	default:
+		runOverview(sub, *format, filter, *failOnEmpty)
+	}
+}
+
+// runOverview handles the apps/tests/e2e/tools/libs/all/"" subcommands.
+func runOverview(sub, format string, filter targetFilter, failOnEmpty bool) {
 	valid := map[string]bool{
 		"":      true,
 		"apps":  true,
@@ -57,38 +178,345 @@ func main() {
 	}
 	if !valid[sub] {
 		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", sub)
-		fmt.Fprintf(os.Stderr, "Usage: bazel run //tools/help -- [apps|tests|e2e|tools|libs|all]\n")
+		fmt.Fprintf(os.Stderr, "Usage: bazel run //tools/help -- [apps|tests|e2e|tools|libs|all|deps|rdeps|graph] [flags]\n")
 		os.Exit(1)
 	}
 
-	// Detect workspace name from MODULE.bazel or WORKSPACE.
 	wsName := detectWorkspaceName()
+	cats := filterCategories(collectAll(), filter)
 
-	// Collect all categories.
-	cats := collectAll()
-
-	// Print header.
-	fmt.Println()
-	fmt.Println(wsName)
-	fmt.Println(strings.Repeat("=", len(wsName)))
-	fmt.Println()
-
+	var selected []*category
 	switch sub {
 	case "", "all":
-		for _, c := range cats {
-			printCategory(c, sub == "all")
-		}
+		selected = cats
 	case "apps":
-		printCategory(cats[0], true)
+		selected = cats[0:1]
 	case "tests":
-		printCategory(cats[1], true)
+		selected = cats[1:2]
 	case "e2e":
-		printCategory(cats[2], true)
+		selected = cats[2:3]
 	case "tools":
-		printCategory(cats[3], true)
+		selected = cats[3:4]
 	case "libs":
-		printCategory(cats[4], true)
+		selected = cats[4:5]
 	}
+
+	emit(wsName, selected, format, func() {
+		for _, c := range selected {
+			printCategory(c, sub == "all")
+		}
+	})
+
+	failIfEmpty(selected, failOnEmpty)
+}
+
+// runQuery handles the deps/rdeps subcommands: it runs `deps(label)` or
+// `rdeps(//..., label)`, categorizes the results the same way the top-level
+// overview does, and prints them the same way.
+func runQuery(sub, label, format string, filter targetFilter, failOnEmpty bool) {
+	var expr string
+	if sub == "deps" {
+		expr = fmt.Sprintf("deps(%s)", label)
+	} else {
+		expr = fmt.Sprintf("rdeps(//..., %s)", label)
+	}
+
+	wsName := detectWorkspaceName()
+	cats := filterCategories(categorize(queryTargetsWithKind(expr)), filter)
+
+	emit(wsName, cats, format, func() {
+		fmt.Printf("%s %s\n\n", sub, label)
+		for _, c := range cats {
+			printCategory(c, true)
+		}
+	})
+
+	failIfEmpty(cats, failOnEmpty)
+}
+
+// graphCategoryColors/langColors are the cluster and node fill colors used
+// by renderDOT/renderMermaid.
+var graphCategoryColors = map[string]string{
+	"Apps":      "#4C72B0",
+	"Tests":     "#55A868",
+	"E2E Tests": "#C44E52",
+	"Tools":     "#8172B2",
+	"Libraries": "#937860",
+	"Other":     "#999999",
+}
+
+var graphLangColors = map[string]string{
+	"Go":         "#e6f7fb",
+	"Zig":        "#fdf0db",
+	"C/C++":      "#dbeaf5",
+	"Rust":       "#fbeee6",
+	"Python":     "#e4ecf7",
+	"Java":       "#f3ece0",
+	"Shell":      "#eaf8e1",
+	"TypeScript": "#e3eefc",
+	"":           "#f0f0f0",
+}
+
+// graphEdgeRe/graphNodeRe parse `bazel query --output=graph`'s DOT text,
+// e.g. `  "//a:a" -> "//b:b"` for an edge or `  "//a:a"` for a bare node.
+var (
+	graphEdgeRe = regexp.MustCompile(`"([^"]+)"\s*->\s*"([^"]+)"`)
+	graphNodeRe = regexp.MustCompile(`^\s*"([^"]+)"\s*;?\s*$`)
+)
+
+// runGraph handles the graph subcommand: it resolves scope/edges/focus into
+// a bazel query, extracts the resulting dependency graph, categorizes its
+// nodes the same way the overview does, and renders DOT or Mermaid.
+func runGraph(kind, scope, edgesMode, focus, outPath string, filter targetFilter, failOnEmpty bool) {
+	expr, err := graphQueryExpr(scope, edgesMode, focus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "help: %v\n", err)
+		os.Exit(1)
+	}
+
+	nodes, edges := queryGraph(expr)
+	if len(nodes) == 0 {
+		fmt.Fprintln(os.Stderr, "help: graph: no targets matched")
+		failIfEmpty(nil, failOnEmpty)
+		return
+	}
+
+	targets := queryTargetsWithKind(fmt.Sprintf("set(%s)", strings.Join(nodes, " ")))
+	cats := filterCategories(graphCategorize(targets), filter)
+
+	kept := make(map[string]bool)
+	for _, c := range cats {
+		for _, t := range c.targets {
+			kept[t.label] = true
+		}
+	}
+	var keptEdges [][2]string
+	for _, e := range edges {
+		if kept[e[0]] && kept[e[1]] {
+			keptEdges = append(keptEdges, e)
+		}
+	}
+
+	var rendered string
+	if kind == "mermaid" {
+		rendered = renderMermaid(cats, keptEdges)
+	} else {
+		rendered = renderDOT(cats, keptEdges)
+	}
+
+	if outPath == "" {
+		fmt.Print(rendered)
+	} else if err := os.WriteFile(outPath, []byte(rendered), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "help: %v\n", err)
+		os.Exit(1)
+	} else {
+		fmt.Printf("help: wrote %s\n", outPath)
+	}
+
+	failIfEmpty(cats, failOnEmpty)
+}
+
+// graphQueryExpr builds the bazel query expression for the graph subcommand.
+// --focus restricts the graph to paths from focus into scope (e.g. "what
+// does this new app pull in from //lib/..."); otherwise scope is expanded in
+// the requested edge direction.
+func graphQueryExpr(scope, edgesMode, focus string) (string, error) {
+	if focus != "" {
+		return fmt.Sprintf("allpaths(%s, %s)", focus, scope), nil
+	}
+	switch edgesMode {
+	case "deps":
+		return fmt.Sprintf("deps(%s)", scope), nil
+	case "rdeps":
+		return fmt.Sprintf("rdeps(//..., %s)", scope), nil
+	case "both":
+		return fmt.Sprintf("deps(%s) union rdeps(//..., %s)", scope, scope), nil
+	default:
+		return "", fmt.Errorf("graph: unknown --edges %q (want deps, rdeps, or both)", edgesMode)
+	}
+}
+
+// queryGraph runs query with `bazel query --output=graph` and parses its DOT
+// text into a node list and an edge list, using the same separate
+// --output_base as queryTargetsWithKind.
+func queryGraph(query string) ([]string, [][2]string) {
+	wsDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+
+	args := []string{
+		"--output_base=" + queryOutputBase(),
+		"query",
+		query,
+		"--output=graph",
+		"--keep_going",
+		"--noshow_progress",
+	}
+	cmd := exec.Command("bazel", args...)
+	if wsDir != "" {
+		cmd.Dir = wsDir
+	}
+	cmd.Stderr = nil
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	var nodes []string
+	addNode := func(label string) {
+		if !strings.HasPrefix(label, "//") || seen[label] {
+			return
+		}
+		seen[label] = true
+		nodes = append(nodes, label)
+	}
+
+	var edges [][2]string
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := graphEdgeRe.FindStringSubmatch(line); m != nil {
+			addNode(m[1])
+			addNode(m[2])
+			edges = append(edges, [2]string{m[1], m[2]})
+			continue
+		}
+		if m := graphNodeRe.FindStringSubmatch(line); m != nil {
+			addNode(m[1])
+		}
+	}
+	return nodes, edges
+}
+
+// graphCategorize is like categorize, but keeps targets that don't fit one
+// of the five overview buckets (e.g. a filegroup pulled in as a dep) in an
+// "Other" bucket instead of dropping them — dropping a node here would leave
+// dangling edges in the rendered graph.
+func graphCategorize(targets []target) []*category {
+	cats := make([]*category, 0, len(categoryMeta)+1)
+	byTitle := make(map[string]*category, len(categoryMeta)+1)
+	for _, m := range categoryMeta {
+		c := &category{icon: m.icon, title: m.title, hint: m.hint}
+		cats = append(cats, c)
+		byTitle[m.title] = c
+	}
+	other := &category{icon: "◽", title: "Other"}
+	cats = append(cats, other)
+
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if seen[t.label] {
+			continue
+		}
+		seen[t.label] = true
+		if title := classifyTarget(t); title != "" {
+			byTitle[title].targets = append(byTitle[title].targets, t)
+		} else {
+			other.targets = append(other.targets, t)
+		}
+	}
+	for _, c := range cats {
+		sortTargets(c.targets)
+	}
+	return cats
+}
+
+// renderDOT renders cats/edges as a Graphviz DOT digraph, one subgraph
+// cluster per non-empty category, nodes colored by language.
+func renderDOT(cats []*category, edges [][2]string) string {
+	var b strings.Builder
+	b.WriteString("digraph deps {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled, fontname=\"monospace\"];\n\n")
+
+	for i, c := range cats {
+		if len(c.targets) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%q;\n", c.icon+" "+c.title)
+		fmt.Fprintf(&b, "    color=%q;\n", graphCategoryColors[c.title])
+		for _, t := range c.targets {
+			fmt.Fprintf(&b, "    %q [label=%q, fillcolor=%q];\n", t.label, shortenLabel(t.label), graphLangColors[t.lang])
+		}
+		b.WriteString("  }\n\n")
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %q -> %q;\n", e[0], e[1])
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderMermaid renders cats/edges as a Mermaid flowchart, one subgraph per
+// non-empty category. Mermaid node IDs can't contain Bazel label characters
+// like "/" or ":", so nodes are assigned sequential ids and labeled with
+// shortenLabel output instead.
+func renderMermaid(cats []*category, edges [][2]string) string {
+	ids := make(map[string]string)
+	n := 0
+	nodeID := func(label string) string {
+		if id, ok := ids[label]; ok {
+			return id
+		}
+		id := fmt.Sprintf("n%d", n)
+		n++
+		ids[label] = id
+		return id
+	}
+
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for i, c := range cats {
+		if len(c.targets) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  subgraph cluster%d [\"%s %s\"]\n", i, c.icon, c.title)
+		for _, t := range c.targets {
+			fmt.Fprintf(&b, "    %s[%q]\n", nodeID(t.label), shortenLabel(t.label))
+		}
+		b.WriteString("  end\n")
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", nodeID(e[0]), nodeID(e[1]))
+	}
+	return b.String()
+}
+
+// emit writes cats in the requested format: JSON/NDJSON go straight to
+// stdout; text prints the workspace header followed by printText's output.
+func emit(wsName string, cats []*category, format string, printText func()) {
+	switch format {
+	case "json":
+		if err := emitJSON(wsName, cats); err != nil {
+			fmt.Fprintf(os.Stderr, "help: %v\n", err)
+			os.Exit(1)
+		}
+	case "ndjson":
+		if err := emitNDJSON(cats); err != nil {
+			fmt.Fprintf(os.Stderr, "help: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println()
+		fmt.Println(wsName)
+		fmt.Println(strings.Repeat("=", len(wsName)))
+		fmt.Println()
+		printText()
+	}
+}
+
+// failIfEmpty exits 1 if requested and every category in cats is empty —
+// useful in CI to assert that e.g. the e2e category is non-empty.
+func failIfEmpty(cats []*category, failOnEmpty bool) {
+	if !failOnEmpty {
+		return
+	}
+	for _, c := range cats {
+		if len(c.targets) > 0 {
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, "help: --fail-on-empty: no targets matched")
+	os.Exit(1)
 }
 
 // collectAll runs bazel queries and returns categorized results.
@@ -179,12 +607,99 @@ func collectAll() []*category {
 	sortTargets(libs)
 
 	return []*category{
-		{icon: "📦", title: "Apps", hint: "bazel run", targets: apps},
-		{icon: "🧪", title: "Tests", hint: "bazel test", targets: tests},
-		{icon: "🔌", title: "E2E Tests", hint: "bazel test --test_tag_filters=e2e", targets: e2e},
-		{icon: "🔧", title: "Tools", hint: "bazel run", targets: tools},
-		{icon: "📋", title: "Libraries", hint: "", targets: libs},
+		{icon: categoryMeta[0].icon, title: categoryMeta[0].title, hint: categoryMeta[0].hint, targets: apps},
+		{icon: categoryMeta[1].icon, title: categoryMeta[1].title, hint: categoryMeta[1].hint, targets: tests},
+		{icon: categoryMeta[2].icon, title: categoryMeta[2].title, hint: categoryMeta[2].hint, targets: e2e},
+		{icon: categoryMeta[3].icon, title: categoryMeta[3].title, hint: categoryMeta[3].hint, targets: tools},
+		{icon: categoryMeta[4].icon, title: categoryMeta[4].title, hint: categoryMeta[4].hint, targets: libs},
+	}
+}
+
+// classifyTarget assigns t to a bucket based on its rule kind and tags
+// alone, or "" if it doesn't belong in the overview at all (e.g. a genrule,
+// or a manual-tagged target). This follows collectAll's rules except that
+// manual-tagged tools, libraries and e2e/integration targets are left out,
+// and only *_test rules land in E2E Tests.
+func classifyTarget(t target) string {
+	tagSet := make(map[string]bool, len(t.tags))
+	for _, tag := range t.tags {
+		tagSet[tag] = true
+	}
+	if tagSet["manual"] {
+		return ""
+	}
+
+	switch {
+	case strings.HasSuffix(t.kind, "_test"):
+		if tagSet["e2e"] || tagSet["integration"] {
+			return "E2E Tests"
+		}
+		return "Tests"
+	case strings.HasSuffix(t.kind, "_binary") || t.kind == "esp_flash":
+		if isToolTarget(t.label) {
+			return "Tools"
+		}
+		if isInternalTarget(t.label) {
+			return ""
+		}
+		return "Apps"
+	case strings.HasSuffix(t.kind, "_library"):
+		if isInternalLibrary(t.label) {
+			return ""
+		}
+		return "Libraries"
+	default:
+		return ""
+	}
+}
+
+// categorize buckets an ad-hoc target list (e.g. the result of a deps/rdeps
+// query) with classifyTarget, which mostly matches how collectAll buckets
+// the whole workspace.
+func categorize(targets []target) []*category {
+	cats := make([]*category, len(categoryMeta))
+	byTitle := make(map[string]*category, len(categoryMeta))
+	for i, m := range categoryMeta {
+		c := &category{icon: m.icon, title: m.title, hint: m.hint}
+		cats[i] = c
+		byTitle[m.title] = c
 	}
+
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if seen[t.label] {
+			continue
+		}
+		title := classifyTarget(t)
+		if title == "" {
+			continue
+		}
+		seen[t.label] = true
+		byTitle[title].targets = append(byTitle[title].targets, t)
+	}
+	for _, c := range cats {
+		sortTargets(c.targets)
+	}
+	return cats
+}
+
+// filterCategories applies a targetFilter uniformly across every category,
+// returning new categories so the originals are left untouched.
+func filterCategories(cats []*category, f targetFilter) []*category {
+	if f.empty() {
+		return cats
+	}
+	out := make([]*category, len(cats))
+	for i, c := range cats {
+		filtered := &category{icon: c.icon, title: c.title, hint: c.hint}
+		for _, t := range c.targets {
+			if f.matches(t) {
+				filtered.targets = append(filtered.targets, t)
+			}
+		}
+		out[i] = filtered
+	}
+	return out
 }
 
 // sortTargets sorts targets by label.
@@ -242,6 +757,69 @@ func printCategory(c *category, detailed bool) {
 	fmt.Println()
 }
 
+// jsonTarget is the wire format for a single target in --format=json/ndjson.
+type jsonTarget struct {
+	Label   string   `json:"label"`
+	Kind    string   `json:"kind"`
+	Lang    string   `json:"lang"`
+	Tags    []string `json:"tags"`
+	Package string   `json:"package"`
+}
+
+// jsonCategory is the wire format for one category in --format=json.
+type jsonCategory struct {
+	Title   string       `json:"title"`
+	Hint    string       `json:"hint"`
+	Targets []jsonTarget `json:"targets"`
+}
+
+// jsonOutput is the top-level --format=json document.
+type jsonOutput struct {
+	Workspace  string         `json:"workspace"`
+	Categories []jsonCategory `json:"categories"`
+}
+
+func toJSONTarget(t target) jsonTarget {
+	return jsonTarget{Label: t.label, Kind: t.kind, Lang: t.lang, Tags: t.tags, Package: t.pkg}
+}
+
+func toJSONCategories(cats []*category) []jsonCategory {
+	out := make([]jsonCategory, 0, len(cats))
+	for _, c := range cats {
+		jc := jsonCategory{Title: c.title, Hint: c.hint, Targets: []jsonTarget{}}
+		for _, t := range c.targets {
+			jc.Targets = append(jc.Targets, toJSONTarget(t))
+		}
+		out = append(out, jc)
+	}
+	return out
+}
+
+// emitJSON writes the full {workspace, categories} document to stdout.
+func emitJSON(wsName string, cats []*category) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonOutput{Workspace: wsName, Categories: toJSONCategories(cats)})
+}
+
+// emitNDJSON writes one JSON object per target, each tagged with its
+// category, newline-delimited — easier to stream/grep than --format=json.
+func emitNDJSON(cats []*category) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, c := range cats {
+		for _, t := range c.targets {
+			record := struct {
+				Category string `json:"category"`
+				jsonTarget
+			}{Category: c.title, jsonTarget: toJSONTarget(t)}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // queryOutputBase returns a separate output_base path so nested bazel query
 // doesn't conflict with the parent `bazel run` server lock.
 func queryOutputBase() string {
@@ -255,9 +833,9 @@ func queryOutputBase() string {
 	return filepath.Join(os.TempDir(), name)
 }
 
-// queryTargetsWithKind runs a bazel query with --output=label_kind
-// and returns targets with language info inferred from the rule kind.
-// Uses a separate --output_base to avoid lock conflict with `bazel run`.
+// queryTargetsWithKind runs a bazel query with --output=jsonproto and
+// returns targets with kind, tags, and package populated. Uses a separate
+// --output_base to avoid lock conflict with `bazel run`.
 func queryTargetsWithKind(query string) []target {
 	wsDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
 
@@ -265,7 +843,7 @@ func queryTargetsWithKind(query string) []target {
 		"--output_base=" + queryOutputBase(),
 		"query",
 		query,
-		"--output=label_kind",
+		"--output=jsonproto",
 		"--keep_going",
 		"--noshow_progress",
 	}
@@ -275,41 +853,66 @@ func queryTargetsWithKind(query string) []target {
 	}
 	cmd.Stderr = nil // suppress stderr
 	out, err := cmd.Output()
-	if err != nil {
-		if len(out) == 0 {
-			return nil
-		}
+	if err != nil && len(out) == 0 {
+		return nil
 	}
-	return parseLabelKinds(string(out))
+	return parseQueryJSON(out)
+}
+
+// bazelQueryJSON mirrors the subset of `bazel query --output=jsonproto`'s
+// schema this tool needs: each target's label, rule kind, and tags.
+type bazelQueryJSON struct {
+	Target []struct {
+		Rule struct {
+			Name      string `json:"name"`
+			RuleClass string `json:"ruleClass"`
+			Attribute []struct {
+				Name            string   `json:"name"`
+				StringListValue []string `json:"stringListValue"`
+			} `json:"attribute"`
+		} `json:"rule"`
+	} `json:"target"`
 }
 
-// parseLabelKinds parses `--output=label_kind` output.
-// Each line looks like: "go_binary rule //tools/help:help_bin"
-func parseLabelKinds(output string) []target {
+// parseQueryJSON parses `--output=jsonproto` output into targets.
+func parseQueryJSON(data []byte) []target {
+	var parsed bazelQueryJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil
+	}
+
 	var targets []target
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		// Format: "<kind> rule <label>"
-		parts := strings.SplitN(line, " ", 3)
-		if len(parts) < 3 {
-			continue
-		}
-		kind := parts[0]
-		label := strings.TrimSpace(parts[2])
+	for _, raw := range parsed.Target {
+		label := raw.Rule.Name
 		if !strings.HasPrefix(label, "//") {
 			continue
 		}
+		var tags []string
+		for _, attr := range raw.Rule.Attribute {
+			if attr.Name == "tags" {
+				tags = attr.StringListValue
+			}
+		}
 		targets = append(targets, target{
 			label: label,
-			lang:  langFromKind(kind),
+			kind:  raw.Rule.RuleClass,
+			lang:  langFromKind(raw.Rule.RuleClass),
+			tags:  tags,
+			pkg:   targetPackage(label),
 		})
 	}
 	return targets
 }
 
+// targetPackage extracts the package portion of a label.
+// e.g. "//lib/pkg/tls:tls" -> "//lib/pkg/tls"
+func targetPackage(label string) string {
+	if i := strings.LastIndex(label, ":"); i >= 0 {
+		return label[:i]
+	}
+	return label
+}
+
 // langFromKind infers the programming language from a Bazel rule kind.
 func langFromKind(kind string) string {
 	switch {
@@ -0,0 +1,292 @@
+// devca is a small in-tree certificate authority, extracted from
+// generateCertBundle's per-key-type CA/server/client cert minting, exposed
+// over an ACME-shaped HTTP API on --ca-port so the embedded Zig client can
+// exercise its ACME/x509 parsing paths against a deterministic CA without
+// any network access. It is not a conformant ACME server: no account
+// registration, nonces, or JWS request signing — just enough of newOrder/
+// finalize/ca to give a client binary real CSR-signing and cert-chain
+// bytes to parse.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeOrder tracks one /newOrder call through to /finalize.
+type acmeOrder struct {
+	ID            string
+	Domains       []string
+	KeyAuthDigest string // hex sha256 key-authorization digest, for tls-alpn-01
+	Status        string // "pending" | "valid"
+}
+
+// DevCA serves the ACME-style issuance API against the same per-key-type
+// CertBundles the TLS test matrix already trusts.
+type DevCA struct {
+	mu      sync.Mutex
+	bundles map[string]*CertBundle
+	orders  map[string]*acmeOrder
+	seq     uint64
+}
+
+func newDevCA(bundles map[string]*CertBundle) *DevCA {
+	return &DevCA{bundles: bundles, orders: make(map[string]*acmeOrder)}
+}
+
+func (d *DevCA) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/newOrder", d.handleNewOrder)
+	mux.HandleFunc("/finalize/", d.handleFinalize)
+	mux.HandleFunc("/ca/", d.handleGetCA)
+	return mux
+}
+
+func (d *DevCA) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Domains                []string `json:"domains"`
+		KeyAuthorizationDigest string   `json:"keyAuthorizationDigest,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Domains) == 0 {
+		http.Error(w, "domains required", http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	d.seq++
+	id := fmt.Sprintf("order-%d", d.seq)
+	d.orders[id] = &acmeOrder{ID: id, Domains: req.Domains, KeyAuthDigest: req.KeyAuthorizationDigest, Status: "pending"}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       id,
+		"status":   "pending",
+		"domains":  req.Domains,
+		"finalize": fmt.Sprintf("/finalize/%s", id),
+	})
+}
+
+func (d *DevCA) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/finalize/")
+
+	d.mu.Lock()
+	order, ok := d.orders[id]
+	d.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown order", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		CSR string `json:"csr"` // base64-encoded DER CertificateRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	csrDER, err := base64.StdEncoding.DecodeString(req.CSR)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad csr encoding: %v", err), http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parse csr: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		http.Error(w, fmt.Sprintf("csr signature: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	keyType := devCAKeyTypeFor(csr.PublicKey)
+	bundle, ok := d.bundles[keyType]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no CA for key type %q", keyType), http.StatusBadRequest)
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("serial: %v", err), http.StatusInternalServerError)
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: order.Domains[0]},
+		DNSNames:     order.Domains,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, template, bundle.CACert, csr.PublicKey, bundle.CAKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("sign: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	d.mu.Lock()
+	order.Status = "valid"
+	d.mu.Unlock()
+
+	// DER-encoded chain: leaf followed by the issuing CA, back to back.
+	w.Header().Set("Content-Type", "application/pkix-cert")
+	w.Write(leafDER)
+	w.Write(bundle.CACert.Raw)
+}
+
+func (d *DevCA) handleGetCA(w http.ResponseWriter, r *http.Request) {
+	keyType := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/ca/"), ".pem")
+	bundle, ok := d.bundles[keyType]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown key type %q", keyType), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(bundle.CACertPEM)
+}
+
+func devCAKeyTypeFor(pub interface{}) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P384() {
+			return "ecdsa-p384"
+		}
+		return "ecdsa-p256"
+	default:
+		return "ecdsa-p256"
+	}
+}
+
+// digestForDomain looks up the tls-alpn-01 key-authorization digest a
+// pending order registered for domain, if any.
+func (d *DevCA) digestForDomain(domain string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, order := range d.orders {
+		if order.KeyAuthDigest == "" {
+			continue
+		}
+		for _, dom := range order.Domains {
+			if dom == domain {
+				return order.KeyAuthDigest
+			}
+		}
+	}
+	return ""
+}
+
+// validationCert mints a short-lived self-signed certificate for the
+// tls-alpn-01 challenge. RFC 8737 carries the key-authorization digest in a
+// critical id-pe-acmeIdentifier extension; this is a simplified stand-in
+// that carries it as a SAN DNS label instead, which is enough to exercise a
+// Zig client's SAN/x509 parsing without a custom extension OID.
+func (d *DevCA) validationCert(domain, keyAuthDigestHex string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	digestLabel := fmt.Sprintf("%s.acme-key-auth.invalid", keyAuthDigestHex)
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain, digestLabel},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(10 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// runDevCA starts the ACME-style HTTP API in the background and returns
+// immediately; callers that also want tls-alpn-01 should call
+// runTLSALPN01 on the returned *DevCA.
+func runDevCA(caPort int, bundles map[string]*CertBundle) *DevCA {
+	d := newDevCA(bundles)
+	go func() {
+		log.Printf("[devca] ACME-style issuance API listening on :%d", caPort)
+		if err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", caPort), d.mux()); err != nil {
+			log.Printf("[devca] HTTP API stopped: %v", err)
+		}
+	}()
+	return d
+}
+
+// runTLSALPN01 serves the tls-alpn-01 challenge: a client completing the
+// handshake with ALPN "acme-tls/1" to a domain with a pending digest is the
+// entire validation — there is nothing further to read or write.
+func (d *DevCA) runTLSALPN01(port int) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		log.Printf("[devca] tls-alpn-01 listener failed: %v", err)
+		return
+	}
+
+	config := &tls.Config{
+		NextProtos: []string{"acme-tls/1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			digest := d.digestForDomain(hello.ServerName)
+			if digest == "" {
+				return nil, fmt.Errorf("no pending tls-alpn-01 validation for %q", hello.ServerName)
+			}
+			return d.validationCert(hello.ServerName, digest)
+		},
+	}
+
+	tlsListener := tls.NewListener(listener, config)
+	log.Printf("[devca] tls-alpn-01 validation listening on :%d", port)
+	for {
+		conn, err := tlsListener.Accept()
+		if err != nil {
+			continue
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			tlsConn, ok := c.(*tls.Conn)
+			if !ok {
+				return
+			}
+			tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+			tlsConn.Handshake()
+		}(conn)
+	}
+}
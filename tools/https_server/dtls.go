@@ -0,0 +1,252 @@
+// DTLS 1.2/1.3 companion server.
+//
+// Go's standard library has no DTLS implementation at all (crypto/tls is
+// stream/TCP-oriented only), and this tree has no DTLS-capable dependency
+// to reach for instead. A real handshake — key schedule, record encryption,
+// certificate verification — is out of reach here. What IS reachable, and
+// what the dtls_* test cases below exercise, is the record/datagram
+// plumbing a DTLS stack sits on top of: the stateless HelloVerifyRequest
+// cookie round trip, epoch/sequence-numbered record headers, handshake
+// message fragmentation and reassembly across an MTU, and deterministic
+// datagram loss/reorder to exercise a client's retransmission logic. No
+// cipher suite is ever negotiated and no application data is encrypted;
+// the final informational datagram each flow sends is plaintext JSON,
+// analogous to the TCP server's /test response.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"sync"
+)
+
+const (
+	dtlsRecordHeaderLen    = 13 // content type(1) + version(2) + epoch(2) + seq(6) + length(2)
+	dtlsHandshakeHeaderLen = 12 // msg_type(1) + length(3) + message_seq(2) + fragment_offset(3) + fragment_length(3)
+
+	dtlsContentTypeHandshake   = 22
+	dtlsContentTypeAppData    = 23
+	dtlsHandshakeClientHello   = 1
+	dtlsHandshakeHelloVerify   = 3
+)
+
+var dtlsVersion = [2]byte{0xfe, 0xfd} // DTLS 1.2 on the wire; see doc comment above
+
+// dtlsCookieSecret is generated once per process and used to derive
+// stateless HelloVerifyRequest cookies (RFC 6347 §4.2.1).
+var dtlsCookieSecret = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand failing means the process can't do anything useful anyway
+	}
+	return b
+}()
+
+func dtlsCookie(addr net.Addr) []byte {
+	mac := hmac.New(sha256.New, dtlsCookieSecret)
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:16]
+}
+
+// dtlsShouldDrop deterministically decides whether the fragment at
+// ordinal n (0-based) of testName's flight should be dropped, seeded from
+// the test name so a run is reproducible without needing real randomness.
+func dtlsShouldDrop(testName string, n int, dropPct float64) bool {
+	if dropPct <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", testName, n)
+	return float64(h.Sum32()%100) < dropPct*100
+}
+
+func writeDTLSRecord(conn net.PacketConn, addr net.Addr, seq uint64, contentType byte, body []byte) error {
+	rec := make([]byte, dtlsRecordHeaderLen+len(body))
+	rec[0] = contentType
+	rec[1], rec[2] = dtlsVersion[0], dtlsVersion[1]
+	binary.BigEndian.PutUint16(rec[3:5], 0) // epoch 0: no cipher suite is ever negotiated here
+	var seqBuf [8]byte
+	binary.BigEndian.PutUint64(seqBuf[:], seq)
+	copy(rec[5:11], seqBuf[2:8]) // 48-bit sequence number
+	binary.BigEndian.PutUint16(rec[11:13], uint16(len(body)))
+	copy(rec[13:], body)
+	_, err := conn.WriteTo(rec, addr)
+	return err
+}
+
+// dtlsHandshakeFragment builds one fragment of a (synthetic) handshake
+// message per RFC 6347 §4.2.2: msg_type/total length/message_seq are the
+// same across all fragments, fragment_offset/fragment_length vary.
+func dtlsHandshakeFragment(msgType byte, msgSeq uint16, full []byte, offset, length int) []byte {
+	frag := make([]byte, dtlsHandshakeHeaderLen+length)
+	frag[0] = msgType
+	frag[1], frag[2], frag[3] = byte(len(full)>>16), byte(len(full)>>8), byte(len(full))
+	binary.BigEndian.PutUint16(frag[4:6], msgSeq)
+	frag[6], frag[7], frag[8] = byte(offset>>16), byte(offset>>8), byte(offset)
+	frag[9], frag[10], frag[11] = byte(length>>16), byte(length>>8), byte(length)
+	copy(frag[12:], full[offset:offset+length])
+	return frag
+}
+
+// sendFragmentedFlight splits full into MTU-budgeted fragments, applies
+// tc's deterministic drop/reorder knobs, and writes each surviving
+// fragment as its own DTLS record. Returns the serialized fragments (for
+// retransmission on a duplicate ClientHello) and the next free sequence
+// number.
+func sendFragmentedFlight(conn net.PacketConn, addr net.Addr, tc TestCase, mtu int, dropPct float64, msgType byte, msgSeq uint16, full []byte, startSeq uint64) ([][]byte, uint64, error) {
+	budget := mtu - dtlsRecordHeaderLen - dtlsHandshakeHeaderLen
+	if budget < 64 {
+		budget = 64
+	}
+
+	var fragments [][]byte
+	for offset := 0; offset < len(full); offset += budget {
+		end := offset + budget
+		if end > len(full) {
+			end = len(full)
+		}
+		fragments = append(fragments, dtlsHandshakeFragment(msgType, msgSeq, full, offset, end-offset))
+	}
+
+	if tc.Reorder && len(fragments) >= 2 {
+		fragments[0], fragments[1] = fragments[1], fragments[0]
+	}
+
+	seq := startSeq
+	for i, frag := range fragments {
+		if dtlsShouldDrop(tc.Name, i, dropPct) {
+			seq++
+			continue
+		}
+		if err := writeDTLSRecord(conn, addr, seq, dtlsContentTypeHandshake, frag); err != nil {
+			return fragments, seq, err
+		}
+		seq++
+	}
+	return fragments, seq, nil
+}
+
+func resendFragments(conn net.PacketConn, addr net.Addr, fragments [][]byte, startSeq uint64) (uint64, error) {
+	seq := startSeq
+	for _, frag := range fragments {
+		if err := writeDTLSRecord(conn, addr, seq, dtlsContentTypeHandshake, frag); err != nil {
+			return seq, err
+		}
+		seq++
+	}
+	return seq, nil
+}
+
+// dtlsClientState tracks one client address through the cookie exchange
+// and, once verified, caches the flight it was sent so a retransmitted
+// ClientHello (the client didn't see the original flight) gets the exact
+// same bytes back instead of a freshly re-split one.
+type dtlsClientState struct {
+	verified  bool
+	flight    [][]byte
+	nextSeq   uint64
+}
+
+// runDTLSServer serves one DTLS TestCase on port, handling sequential
+// clients the same way runTestServer's Accept loop does for TCP.
+func runDTLSServer(tc TestCase, port int, bundle *CertBundle, mtu int, dropPct float64) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		log.Printf("[%s] Failed to start DTLS listener: %v", tc.Name, err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("[%s] DTLS listening on :%d (mtu=%d drop_pct=%.2f reorder=%v)", tc.Name, port, mtu, dropPct, tc.Reorder)
+
+	var mu sync.Mutex
+	clients := make(map[string]*dtlsClientState)
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+		if n < dtlsRecordHeaderLen || buf[0] != dtlsContentTypeHandshake {
+			continue
+		}
+		handshakeBody := buf[dtlsRecordHeaderLen:n]
+		if len(handshakeBody) < dtlsHandshakeHeaderLen || handshakeBody[0] != dtlsHandshakeClientHello {
+			continue
+		}
+
+		key := addr.String()
+		mu.Lock()
+		st, ok := clients[key]
+		if !ok {
+			st = &dtlsClientState{}
+			clients[key] = st
+		}
+		mu.Unlock()
+
+		if !st.verified {
+			cookie := dtlsCookie(addr)
+			// A real parser would check the ClientHello's cookie field;
+			// here any second datagram from the same address is treated
+			// as the cookie-bearing retry, matching RFC 6347's round trip
+			// shape without decoding the ClientHello body.
+			if !ok {
+				hvr := make([]byte, 3+len(cookie))
+				hvr[0], hvr[1] = dtlsVersion[0], dtlsVersion[1]
+				hvr[2] = byte(len(cookie))
+				copy(hvr[3:], cookie)
+				frag := dtlsHandshakeFragment(dtlsHandshakeHelloVerify, 0, hvr, 0, len(hvr))
+				if err := writeDTLSRecord(conn, addr, 1, dtlsContentTypeHandshake, frag); err != nil {
+					log.Printf("[%s] write HelloVerifyRequest to %s: %v", tc.Name, addr, err)
+				}
+				continue
+			}
+
+			// Cookie-bearing ClientHello: send the synthetic flight. The CA
+			// cert stands in for a Certificate message purely to give the
+			// flight a realistic, fragmentation-worthy size.
+			flight, nextSeq, err := sendFragmentedFlight(conn, addr, tc, mtu, dropPct, 11 /* synthetic Certificate msg_type */, 1, bundle.CACertPEM, 2)
+			if err != nil {
+				log.Printf("[%s] send flight to %s: %v", tc.Name, addr, err)
+				continue
+			}
+			mu.Lock()
+			st.verified = true
+			st.flight = flight
+			st.nextSeq = nextSeq
+			mu.Unlock()
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"test":       tc.Name,
+				"mtu":        mtu,
+				"drop_pct":   dropPct,
+				"reorder":    tc.Reorder,
+				"fragments":  len(flight),
+				"note":       "structural DTLS framing only; no cipher suite negotiated",
+			})
+			if err := writeDTLSRecord(conn, addr, nextSeq, dtlsContentTypeAppData, body); err != nil {
+				log.Printf("[%s] write summary to %s: %v", tc.Name, addr, err)
+			}
+			continue
+		}
+
+		// Duplicate cookie-bearing ClientHello from an already-verified
+		// client: the client never saw (part of) the flight, so resend the
+		// exact cached fragments rather than re-deriving new ones.
+		mu.Lock()
+		flight := st.flight
+		nextSeq := st.nextSeq
+		mu.Unlock()
+		if _, err := resendFragments(conn, addr, flight, nextSeq); err != nil {
+			log.Printf("[%s] retransmit to %s: %v", tc.Name, addr, err)
+		}
+	}
+}
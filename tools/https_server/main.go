@@ -14,6 +14,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -21,6 +22,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -29,6 +31,10 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -44,6 +50,34 @@ type TestCase struct {
 	KeyType      string // "rsa", "ecdsa-p256", "ecdsa-p384"
 	ALPN         []string
 	RequireSNI   bool
+	Resumable    bool // issue extra NewSessionTicket()s post-handshake for resumption testing
+	EarlyData    bool // exercise the 0-RTT accept/reject path on /early
+	MaxEarlyData uint32
+	ClientAuth   tls.ClientAuthType // NoClientCert means mTLS is not exercised for this case
+	BadClientCA  bool               // pin ClientCAs to an unrelated CA so client cert validation fails
+	Fault        *FaultProfile      // nil means no fault injection for this case
+	IsDTLS       bool               // served by runDTLSServer (see dtls.go) instead of the TCP/TLS path
+	MTU          int                // DTLS handshake fragment size in bytes
+	DropPct      float64            // DTLS: deterministic fraction of outbound datagrams to drop
+	Reorder      bool               // DTLS: swap the first two outbound fragments of the flight
+}
+
+// FaultProfile configures a faultConn middlebox that sits between the raw
+// socket and tls.Conn, mutating the server's outbound byte stream to
+// exercise a Zig client's negative-path handling. Ordinals count raw TLS
+// records written to the wire (1-based); crypto/tls does not expose
+// per-handshake-message write boundaries, so "the record containing
+// Finished" is approximated as the single flight record crypto/tls coalesces
+// EncryptedExtensions/Certificate/CertificateVerify/Finished into for
+// TLS 1.3 (ordinal 2: ServerHello is ordinal 1).
+type FaultProfile struct {
+	CorruptRecord        int            // flip the low bit of the last payload byte of this outbound record
+	TruncateAfter        int            // drop everything and close the conn after this many outbound records
+	InjectCCSAfterRecord int            // send an out-of-place ChangeCipherSpec record after this many outbound records
+	OverflowRecord       int            // rewrite this record's length header to exceed the legal max TLS record size
+	InjectAlert          tls.AlertError // alert description to send when AlertAfterRecord is reached
+	AlertAfterRecord     int            // send a raw fatal InjectAlert record after this many outbound records
+	DuplicateRecord      int            // resend this record immediately after it is first written
 }
 
 var testCases = []TestCase{
@@ -67,6 +101,26 @@ var testCases = []TestCase{
 	{Name: "tls13_p384", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
 		CurvePrefs: []tls.CurveID{tls.CurveP384}, KeyType: "ecdsa-p384"},
 
+	// Hybrid post-quantum key exchange: direct path requires the client's
+	// initial key_share to already include the hybrid group.
+	{Name: "tls13_pq_hybrid", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		CurvePrefs: []tls.CurveID{tls.X25519MLKEM768}, KeyType: "ecdsa-p256"},
+
+	// Same server posture as tls13_pq_hybrid — the server only ever accepts
+	// the hybrid group either way. What differs is purely client-side: a
+	// client exercising this case is expected to offer only classical
+	// groups (e.g. X25519) in its initial key_share, so the server can't
+	// complete the handshake without a HelloRetryRequest asking for
+	// X25519MLKEM768, letting the client assert the HRR-driven retry path
+	// rather than the direct one.
+	{Name: "tls13_hrr_pq", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		CurvePrefs: []tls.CurveID{tls.X25519MLKEM768}, KeyType: "ecdsa-p256"},
+
+	// Classical group ordered ahead of the hybrid one, to test that a
+	// client correctly honors CurvePreferences's stated order.
+	{Name: "tls13_pq_mixed_order", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		CurvePrefs: []tls.CurveID{tls.X25519, tls.X25519MLKEM768}, KeyType: "ecdsa-p256"},
+
 	// ==========================================
 	// TLS 1.2 ECDSA Tests
 	// ==========================================
@@ -119,6 +173,81 @@ var testCases = []TestCase{
 	{Name: "ext_alpn_http11", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
 		KeyType: "ecdsa-p256", ALPN: []string{"http/1.1"}},
 
+	// ==========================================
+	// Mutual TLS Tests
+	// ==========================================
+
+	// Client cert required; server pins ClientCAs to its own CA for the key type.
+	{Name: "mtls_ecdsa_required", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", ClientAuth: tls.RequireAndVerifyClientCert},
+
+	// Client cert optional and, if presented, verified.
+	{Name: "mtls_rsa_optional", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "rsa", ClientAuth: tls.VerifyClientCertIfGiven},
+
+	// Server pins ClientCAs to an unrelated CA, so a legitimate client
+	// certificate signed by the real per-keytype CA is rejected — exercises
+	// the negative half of client cert validation.
+	{Name: "mtls_bad_client_ca", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", ClientAuth: tls.RequireAndVerifyClientCert, BadClientCA: true},
+
+	// P-384 client cert request: Go derives the CertificateRequest's
+	// certificate_authorities extension from ClientCAs and its
+	// signature_algorithms from the negotiated cipher suite/version, so
+	// pinning a P-384 CA here is what varies the request across cases —
+	// crypto/tls has no separate knob to override signature_algorithms.
+	{Name: "mtls_p384_request_vary", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p384", ClientAuth: tls.RequireAndVerifyClientCert},
+
+	// ==========================================
+	// Negative-Path / Fault-Injection Tests
+	// ==========================================
+
+	// Corrupt the flight record carrying Finished; client must detect the
+	// bad record MAC and fail the handshake with a fatal alert.
+	{Name: "neg_bad_finished_mac", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Fault: &FaultProfile{CorruptRecord: 2}},
+
+	// Send an out-of-place ChangeCipherSpec record mid-handshake; TLS 1.3
+	// only tolerates the compatibility CCS immediately after ServerHello.
+	{Name: "neg_unexpected_ccs", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Fault: &FaultProfile{InjectCCSAfterRecord: 1}},
+
+	// Claim a record length beyond the legal TLS max (2^14+256); client
+	// must reject on the header alone rather than waiting for more bytes.
+	{Name: "neg_record_overflow", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Fault: &FaultProfile{OverflowRecord: 2}},
+
+	// Send a fatal alert immediately after the Finished flight, before any
+	// application data; client must surface it and close cleanly.
+	{Name: "neg_alert_after_finished", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Fault: &FaultProfile{AlertAfterRecord: 2, InjectAlert: tls.AlertError(10)}},
+
+	// ==========================================
+	// TLS 1.3 Session Resumption / 0-RTT Tests
+	// ==========================================
+
+	// Session resumption: server issues extra NewSessionTicket()s so a
+	// client can reconnect and report ConnectionState.DidResume via /resume.
+	{Name: "tls13_resume_ticket", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Resumable: true},
+
+	// 0-RTT early data: Go's crypto/tls deliberately has no server-side
+	// early-data support (see handleConnection's /early handler), so this
+	// case exercises the "rejected" half of the accept/reject matrix; a
+	// Zig client can still use tls13_resume_ticket for the "accepted" path.
+	{Name: "tls13_0rtt_early_data", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13,
+		KeyType: "ecdsa-p256", Resumable: true, EarlyData: true, MaxEarlyData: 16384},
+
+	// ==========================================
+	// DTLS 1.2/1.3 Tests (served by runDTLSServer, see dtls.go)
+	// ==========================================
+
+	{Name: "dtls_mtu_512", KeyType: "ecdsa-p256", IsDTLS: true, MTU: 512},
+	{Name: "dtls_mtu_1200", KeyType: "ecdsa-p256", IsDTLS: true, MTU: 1200},
+	{Name: "dtls_frag_reorder", KeyType: "ecdsa-p256", IsDTLS: true, MTU: 512, Reorder: true},
+	{Name: "dtls_retransmit", KeyType: "ecdsa-p256", IsDTLS: true, MTU: 512, DropPct: 0.3},
+
 	// ==========================================
 	// Data Transfer Tests
 	// ==========================================
@@ -128,29 +257,55 @@ var testCases = []TestCase{
 		KeyType: "ecdsa-p256"},
 }
 
-// CertBundle holds CA and server certificates for a specific key type
+// CertBundle holds CA, server, and client certificates for a specific key type
 type CertBundle struct {
-	KeyType    string
-	CACert     *x509.Certificate
-	CACertPEM  []byte
-	CAKey      interface{}
-	ServerCert tls.Certificate
+	KeyType       string
+	CACert        *x509.Certificate
+	CACertPEM     []byte
+	CAKey         interface{}
+	ServerCert    tls.Certificate
+	ClientCert    tls.Certificate
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
 }
 
 var certBundles map[string]*CertBundle
 
+// rogueCA signs nothing a real CertBundle trusts; it exists only so
+// mtls_bad_client_ca can pin ClientCAs to a pool that rejects every
+// legitimate client certificate minted by generateCertBundle.
+var rogueCACert *x509.Certificate
+
 func main() {
 	port := flag.Int("port", 8443, "Base server port")
 	caOut := flag.String("ca-out", "", "Write CA certs to directory")
 	listTests := flag.Bool("list", false, "List all test cases")
+	sessionTickets := flag.Int("session-tickets", 2, "Extra NewSessionTicket()s issued post-handshake on Resumable test cases")
+	jsonOutput := flag.String("json-output", "", "Write driver-mode results as a JSON document to this file (default: stdout)")
+	driverCmd := flag.String("driver", "", "Run a matrix against this client binary instead of serving forever")
+	runPattern := flag.String("run", "", "Regexp matched against test case names; only matching cases run (driver mode) or list (--list)")
+	shardSpec := flag.String("shard", "", "Select shard i of n (format i/n, 1-based) from the -run-filtered matrix")
+	dtlsMode := flag.Bool("dtls", false, "Serve only the DTLS test cases (dtls_*), over UDP, instead of the TCP/TLS matrix")
+	mtuOverride := flag.Int("mtu", 0, "Override every DTLS test case's handshake fragment MTU (0 keeps each case's own default)")
+	dropPctOverride := flag.Float64("drop-pct", -1, "Override every DTLS test case's deterministic drop fraction (negative keeps each case's own default)")
+	caPort := flag.Int("ca-port", 0, "Serve a devca ACME-style issuance API (newOrder/finalize/ca) on this port; 0 disables it. tls-alpn-01 validation listens on ca-port+1")
 	flag.Parse()
 
+	include, err := selectTestCases(testCases, *runPattern, *shardSpec)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// List tests and exit
 	if *listTests {
 		fmt.Println("Available test cases:")
 		for i, tc := range testCases {
-			fmt.Printf("  [%2d] %-30s port=%d version=%s key=%s\n",
-				i, tc.Name, *port+i, versionName(tc.MaxVersion), tc.KeyType)
+			marker := " "
+			if !include[i] {
+				marker = "-"
+			}
+			fmt.Printf("%s [%2d] %-30s port=%d version=%s key=%s\n",
+				marker, i, tc.Name, *port+i, versionName(tc.MaxVersion), tc.KeyType)
 		}
 		return
 	}
@@ -172,7 +327,25 @@ func main() {
 		log.Printf("  ✓ %s certificates generated", keyType)
 	}
 
-	// Write CA certs if requested
+	rogueCA, err := generateRogueCA()
+	if err != nil {
+		log.Fatalf("Failed to generate rogue CA: %v", err)
+	}
+	rogueCACert = rogueCA
+
+	if *caPort != 0 {
+		devCA := runDevCA(*caPort, certBundles)
+		go devCA.runTLSALPN01(*caPort + 1)
+	}
+
+	if *driverCmd != "" {
+		if err := runDriverMode(*driverCmd, include, *jsonOutput, *sessionTickets); err != nil {
+			log.Fatalf("driver mode: %v", err)
+		}
+		return
+	}
+
+	// Write CA and client certs if requested
 	if *caOut != "" {
 		os.MkdirAll(*caOut, 0755)
 		for keyType, bundle := range certBundles {
@@ -182,6 +355,20 @@ func main() {
 			} else {
 				log.Printf("  CA cert written to %s", path)
 			}
+
+			clientCertPath := fmt.Sprintf("%s/client_%s.pem", *caOut, keyType)
+			if err := os.WriteFile(clientCertPath, bundle.ClientCertPEM, 0644); err != nil {
+				log.Printf("  Warning: failed to write %s: %v", clientCertPath, err)
+			} else {
+				log.Printf("  Client cert written to %s", clientCertPath)
+			}
+
+			clientKeyPath := fmt.Sprintf("%s/client_%s_key.pem", *caOut, keyType)
+			if err := os.WriteFile(clientKeyPath, bundle.ClientKeyPEM, 0600); err != nil {
+				log.Printf("  Warning: failed to write %s: %v", clientKeyPath, err)
+			} else {
+				log.Printf("  Client key written to %s", clientKeyPath)
+			}
 		}
 	}
 
@@ -200,6 +387,10 @@ func main() {
 		"TLS 1.2 RSA":        {},
 		"TLS 1.2 Curves":     {},
 		"Extensions":         {},
+		"Mutual TLS":         {},
+		"Negative / Faults":  {},
+		"TLS 1.3 Resumption": {},
+		"DTLS":               {},
 		"Data Transfer":      {},
 	}
 
@@ -217,13 +408,21 @@ func main() {
 			categories["TLS 1.2 Curves"] = append(categories["TLS 1.2 Curves"], i)
 		case strings.HasPrefix(tc.Name, "ext_"):
 			categories["Extensions"] = append(categories["Extensions"], i)
+		case strings.HasPrefix(tc.Name, "mtls_"):
+			categories["Mutual TLS"] = append(categories["Mutual TLS"], i)
+		case strings.HasPrefix(tc.Name, "neg_"):
+			categories["Negative / Faults"] = append(categories["Negative / Faults"], i)
+		case strings.HasPrefix(tc.Name, "tls13_resume") || strings.HasPrefix(tc.Name, "tls13_0rtt"):
+			categories["TLS 1.3 Resumption"] = append(categories["TLS 1.3 Resumption"], i)
+		case strings.HasPrefix(tc.Name, "dtls_"):
+			categories["DTLS"] = append(categories["DTLS"], i)
 		case strings.HasPrefix(tc.Name, "data_"):
 			categories["Data Transfer"] = append(categories["Data Transfer"], i)
 		}
 	}
 
 	// Print categorized test cases
-	catOrder := []string{"TLS 1.3 Ciphers", "TLS 1.3 Curves", "TLS 1.2 ECDSA", "TLS 1.2 RSA", "TLS 1.2 Curves", "Extensions", "Data Transfer"}
+	catOrder := []string{"TLS 1.3 Ciphers", "TLS 1.3 Curves", "TLS 1.2 ECDSA", "TLS 1.2 RSA", "TLS 1.2 Curves", "Extensions", "Mutual TLS", "Negative / Faults", "TLS 1.3 Resumption", "DTLS", "Data Transfer"}
 	for _, cat := range catOrder {
 		indices := categories[cat]
 		if len(indices) == 0 {
@@ -238,6 +437,9 @@ func main() {
 
 	// Start all servers
 	for i, tc := range testCases {
+		if tc.IsDTLS != *dtlsMode {
+			continue
+		}
 		bundle := certBundles[tc.KeyType]
 		if bundle == nil {
 			log.Printf("Warning: no cert bundle for %s", tc.KeyType)
@@ -245,10 +447,26 @@ func main() {
 		}
 
 		serverPort := basePort + i
+		mtu := tc.MTU
+		if *mtuOverride > 0 {
+			mtu = *mtuOverride
+		}
+		dropPct := tc.DropPct
+		if *dropPctOverride >= 0 {
+			dropPct = *dropPctOverride
+		}
+
 		wg.Add(1)
+		if tc.IsDTLS {
+			go func(tc TestCase, port int, bundle *CertBundle, mtu int, dropPct float64) {
+				defer wg.Done()
+				runDTLSServer(tc, port, bundle, mtu, dropPct)
+			}(tc, serverPort, bundle, mtu, dropPct)
+			continue
+		}
 		go func(tc TestCase, port int, bundle *CertBundle) {
 			defer wg.Done()
-			runTestServer(tc, port, bundle)
+			runTestServer(tc, port, bundle, *sessionTickets)
 		}(tc, serverPort, bundle)
 	}
 
@@ -271,6 +489,47 @@ func versionName(v uint16) string {
 	}
 }
 
+// faultDescription renders a FaultProfile as a short human/machine-readable
+// string so it can be logged and surfaced in the /test response for cases
+// where the connection survives long enough to reach it.
+func faultDescription(p *FaultProfile) string {
+	if p == nil {
+		return "none"
+	}
+	var parts []string
+	if p.CorruptRecord > 0 {
+		parts = append(parts, fmt.Sprintf("corrupt_record=%d", p.CorruptRecord))
+	}
+	if p.TruncateAfter > 0 {
+		parts = append(parts, fmt.Sprintf("truncate_after=%d", p.TruncateAfter))
+	}
+	if p.InjectCCSAfterRecord > 0 {
+		parts = append(parts, fmt.Sprintf("inject_ccs_after=%d", p.InjectCCSAfterRecord))
+	}
+	if p.OverflowRecord > 0 {
+		parts = append(parts, fmt.Sprintf("overflow_record=%d", p.OverflowRecord))
+	}
+	if p.AlertAfterRecord > 0 {
+		parts = append(parts, fmt.Sprintf("inject_alert=%d@%d", p.InjectAlert, p.AlertAfterRecord))
+	}
+	if p.DuplicateRecord > 0 {
+		parts = append(parts, fmt.Sprintf("duplicate_record=%d", p.DuplicateRecord))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+// peerCertSubject returns the client certificate's subject, if one was
+// presented, for callers exercising mutual-TLS test cases.
+func peerCertSubject(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.String()
+}
+
 func generateCertBundle(keyType string) (*CertBundle, error) {
 	var curve elliptic.Curve
 	var caKey, serverKey interface{}
@@ -356,15 +615,80 @@ func generateCertBundle(keyType string) (*CertBundle, error) {
 		return nil, err
 	}
 
+	// Client certificate, for mutual-TLS test cases
+	var clientKey interface{}
+	switch keyType {
+	case "rsa":
+		clientKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p256":
+		clientKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		clientKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject: pkix.Name{
+			Organization: []string{"Zig TLS Test Client"},
+			CommonName:   fmt.Sprintf("zig-tls-test-client-%s", keyType),
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientCertDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, publicKey(clientKey), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCertDER})
+	clientKeyPEM := pemEncodeKey(clientKey)
+
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CertBundle{
-		KeyType:    keyType,
-		CACert:     caCert,
-		CACertPEM:  caCertPEM,
-		CAKey:      caKey,
-		ServerCert: serverCert,
+		KeyType:       keyType,
+		CACert:        caCert,
+		CACertPEM:     caCertPEM,
+		CAKey:         caKey,
+		ServerCert:    serverCert,
+		ClientCert:    clientCert,
+		ClientCertPEM: clientCertPEM,
+		ClientKeyPEM:  clientKeyPEM,
 	}, nil
 }
 
+// generateRogueCA mints a throwaway CA unrelated to any CertBundle, used to
+// exercise the "client cert rejected" half of mutual-TLS validation.
+func generateRogueCA() (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Rogue Test CA"}, CommonName: "Rogue Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
 func publicKey(key interface{}) interface{} {
 	switch k := key.(type) {
 	case *rsa.PrivateKey:
@@ -394,7 +718,91 @@ func pemEncodeKey(key interface{}) []byte {
 	}
 }
 
-func runTestServer(tc TestCase, port int, bundle *CertBundle) {
+const (
+	recordHeaderLen   = 5 // content type (1) + legacy version (2) + length (2)
+	maxLegalRecordLen = 1<<14 + 256
+)
+
+// faultConn wraps an accepted net.Conn so runTestServer can mutate the raw
+// TLS record stream a server writes to a client, per a FaultProfile.
+// Reads from the client pass through untouched; only the server's outbound
+// bytes are mutated, since that's the half under the test server's control.
+type faultConn struct {
+	net.Conn
+	profile   *FaultProfile
+	mu        sync.Mutex
+	recordNum int
+}
+
+func (fc *faultConn) Write(b []byte) (int, error) {
+	if fc.profile == nil || len(b) < recordHeaderLen {
+		return fc.Conn.Write(b)
+	}
+
+	fc.mu.Lock()
+	fc.recordNum++
+	num := fc.recordNum
+	fc.mu.Unlock()
+	p := fc.profile
+
+	if p.TruncateAfter > 0 && num > p.TruncateAfter {
+		fc.Conn.Close()
+		return len(b), nil
+	}
+
+	if p.InjectCCSAfterRecord > 0 && p.InjectCCSAfterRecord == num-1 {
+		// Out-of-place ChangeCipherSpec: content type 20, legacy record
+		// version 3.3, 1-byte payload (the CCS spec byte is always 0x01).
+		fc.Conn.Write([]byte{20, 3, 3, 0, 1, 1})
+	}
+
+	out := b
+	if p.CorruptRecord == num && len(b) > recordHeaderLen {
+		out = append([]byte(nil), b...)
+		out[len(out)-1] ^= 0x01
+	}
+	if p.OverflowRecord == num {
+		out = append([]byte(nil), out...)
+		overLen := maxLegalRecordLen + 1
+		out[3] = byte(overLen >> 8)
+		out[4] = byte(overLen)
+	}
+
+	n, err := fc.Conn.Write(out)
+	if err != nil {
+		return n, err
+	}
+
+	if p.AlertAfterRecord == num && p.InjectAlert != 0 {
+		// level=fatal(2), description=p.InjectAlert.
+		fc.Conn.Write([]byte{21, 3, 3, 0, 2, 2, byte(p.InjectAlert)})
+	}
+
+	if p.DuplicateRecord == num {
+		fc.Conn.Write(out)
+	}
+
+	return len(b), nil
+}
+
+// faultListener wraps a net.Listener so every accepted connection is routed
+// through a faultConn before tls.NewListener performs the handshake on it.
+type faultListener struct {
+	net.Listener
+	profile *FaultProfile
+}
+
+func (fl *faultListener) Accept() (net.Conn, error) {
+	c, err := fl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: c, profile: fl.profile}, nil
+}
+
+// buildTLSConfig translates a TestCase into a *tls.Config, shared by the
+// continuous multi-port server and driver mode's ephemeral-port listeners.
+func buildTLSConfig(tc TestCase, bundle *CertBundle) *tls.Config {
 	// Create key log file for debugging
 	keyLogFile, _ := os.OpenFile("/tmp/tls_keys.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
@@ -417,6 +825,19 @@ func runTestServer(tc TestCase, port int, bundle *CertBundle) {
 		tlsConfig.NextProtos = tc.ALPN
 	}
 
+	// Mutual TLS: require/request a client cert and pin the pool it's
+	// verified against
+	if tc.ClientAuth != tls.NoClientCert {
+		pool := x509.NewCertPool()
+		if tc.BadClientCA {
+			pool.AddCert(rogueCACert)
+		} else {
+			pool.AddCert(bundle.CACert)
+		}
+		tlsConfig.ClientAuth = tc.ClientAuth
+		tlsConfig.ClientCAs = pool
+	}
+
 	// SNI verification callback
 	if tc.RequireSNI {
 		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
@@ -430,11 +851,22 @@ func runTestServer(tc TestCase, port int, bundle *CertBundle) {
 		}
 	}
 
-	listener, err := tls.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port), tlsConfig)
+	return tlsConfig
+}
+
+func runTestServer(tc TestCase, port int, bundle *CertBundle, sessionTickets int) {
+	tlsConfig := buildTLSConfig(tc, bundle)
+
+	rawListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
 		log.Printf("[%s] Failed to start: %v", tc.Name, err)
 		return
 	}
+	var innerListener net.Listener = rawListener
+	if tc.Fault != nil {
+		innerListener = &faultListener{Listener: rawListener, profile: tc.Fault}
+	}
+	listener := tls.NewListener(innerListener, tlsConfig)
 	defer listener.Close()
 
 	for {
@@ -442,37 +874,75 @@ func runTestServer(tc TestCase, port int, bundle *CertBundle) {
 		if err != nil {
 			continue
 		}
-		go handleConnection(conn, tc)
+		go handleConnection(conn, tc, sessionTickets)
 	}
 }
 
-func handleConnection(conn net.Conn, tc TestCase) {
+// connResult summarizes what happened on the server side of one accepted
+// connection, for driver mode's JSON report. The continuous multi-port
+// server ignores the return value and relies on the log lines instead.
+type connResult struct {
+	HandshakeOK bool
+	Reason      string
+	Version     uint16
+	Cipher      uint16
+	ALPN        string
+	SNI         string
+	BytesEchoed int
+	Elapsed     time.Duration
+}
+
+func handleConnection(conn net.Conn, tc TestCase, sessionTickets int) connResult {
 	defer conn.Close()
 
 	tlsConn, ok := conn.(*tls.Conn)
 	if !ok {
-		return
+		return connResult{Reason: "accepted connection was not a *tls.Conn"}
 	}
 
 	// Set deadline
 	conn.SetDeadline(time.Now().Add(30 * time.Second))
 
+	if tc.Fault != nil {
+		log.Printf("[%s] Fault profile active: %s", tc.Name, faultDescription(tc.Fault))
+	}
+
 	// Complete handshake
 	if err := tlsConn.Handshake(); err != nil {
-		log.Printf("[%s] Handshake failed: %v", tc.Name, err)
-		return
+		log.Printf("[%s] Handshake failed (expected for negative-path cases): %v", tc.Name, err)
+		return connResult{Reason: fmt.Sprintf("handshake failed: %v", err)}
 	}
 
 	state := tlsConn.ConnectionState()
-	log.Printf("[%s] Connected: version=0x%04x cipher=0x%04x alpn=%s sni=%s",
+	log.Printf("[%s] Connected: version=0x%04x cipher=0x%04x alpn=%s sni=%s resumed=%v",
 		tc.Name, state.Version, state.CipherSuite,
-		state.NegotiatedProtocol, state.ServerName)
+		state.NegotiatedProtocol, state.ServerName, state.DidResume)
+
+	// Issue extra session tickets for resumption testing (TLS 1.3 only;
+	// NewSessionTicket returns an error on older versions).
+	if tc.Resumable && state.Version == tls.VersionTLS13 {
+		for i := 0; i < sessionTickets; i++ {
+			if err := tlsConn.NewSessionTicket(); err != nil {
+				log.Printf("[%s] NewSessionTicket failed: %v", tc.Name, err)
+				break
+			}
+		}
+	}
+
+	result := connResult{
+		HandshakeOK: true,
+		Version:     state.Version,
+		Cipher:      state.CipherSuite,
+		ALPN:        state.NegotiatedProtocol,
+		SNI:         state.ServerName,
+	}
 
 	// Read request
 	buf := make([]byte, 65536)
 	n, err := conn.Read(buf)
 	if err != nil && err != io.EOF {
-		return
+		result.Reason = fmt.Sprintf("read request: %v", err)
+		return result
 	}
 
 	request := string(buf[:n])
@@ -492,6 +962,10 @@ func handleConnection(conn net.Conn, tc TestCase) {
   "alpn": "%s",
   "sni": "%s",
   "key_type": "%s",
+  "peer_cert_subject": "%s",
+  "peer_cert_verified": %v,
+  "group": "%s",
+  "fault_applied": "%s",
   "ok": true
 }`,
 			tc.Name,
@@ -499,7 +973,11 @@ func handleConnection(conn net.Conn, tc TestCase) {
 			state.CipherSuite, tls.CipherSuiteName(state.CipherSuite),
 			state.NegotiatedProtocol,
 			state.ServerName,
-			tc.KeyType)
+			tc.KeyType,
+			peerCertSubject(state),
+			len(state.VerifiedChains) > 0,
+			state.CurveID,
+			faultDescription(tc.Fault))
 		response = httpResponse(200, "application/json", body)
 
 	case strings.HasPrefix(request, "GET /large"):
@@ -511,6 +989,37 @@ func handleConnection(conn net.Conn, tc TestCase) {
 		// Echo test
 		response = httpResponse(200, "text/plain", request)
 
+	case strings.HasPrefix(request, "GET /resume"):
+		// Resumption test: client reconnects and checks whether this
+		// handshake resumed a session issued by an earlier connection.
+		body := fmt.Sprintf(`{
+  "test": "%s",
+  "resumed": %v,
+  "version": "0x%04x",
+  "version_name": "%s",
+  "ok": true
+}`,
+			tc.Name, state.DidResume,
+			state.Version, versionName(state.Version))
+		response = httpResponse(200, "application/json", body)
+
+	case strings.HasPrefix(request, "GET /early"):
+		// 0-RTT early data test. Go's crypto/tls has no server-side
+		// early-data acceptance path at all (a deliberate upstream
+		// decision), so early data sent by a client is never read here
+		// and always reports as rejected; a client exercising the
+		// "accepted" half of the matrix should use tls13_resume_ticket
+		// and compare against /resume instead.
+		body := fmt.Sprintf(`{
+  "test": "%s",
+  "early_data_accepted": false,
+  "reason": "go crypto/tls has no server-side 0-RTT support",
+  "max_early_data": %d,
+  "ok": true
+}`,
+			tc.Name, tc.MaxEarlyData)
+		response = httpResponse(200, "application/json", body)
+
 	case strings.HasPrefix(request, "PING"):
 		// Simple ping/pong
 		response = "PONG\n"
@@ -519,7 +1028,9 @@ func handleConnection(conn net.Conn, tc TestCase) {
 		response = httpResponse(404, "text/plain", "Not Found")
 	}
 
-	conn.Write([]byte(response))
+	n2, _ := conn.Write([]byte(response))
+	result.BytesEchoed = n2
+	return result
 }
 
 func httpResponse(status int, contentType, body string) string {
@@ -534,3 +1045,219 @@ func httpResponse(status int, contentType, body string) string {
 	return fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
 		status, statusText, contentType, len(body), body)
 }
+
+// selectTestCases returns, per index into cases, whether that case should
+// run given -run and -shard. Sharding is applied after -run filtering, so
+// shard boundaries stay stable regardless of how many cases a pattern keeps.
+func selectTestCases(cases []TestCase, runPattern, shardSpec string) ([]bool, error) {
+	include := make([]bool, len(cases))
+	for i := range include {
+		include[i] = true
+	}
+
+	if runPattern != "" {
+		re, err := regexp.Compile(runPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -run pattern %q: %w", runPattern, err)
+		}
+		for i, tc := range cases {
+			if !re.MatchString(tc.Name) {
+				include[i] = false
+			}
+		}
+	}
+
+	if shardSpec != "" {
+		parts := strings.SplitN(shardSpec, "/", 2)
+		var shardIndex, shardCount int
+		if len(parts) == 2 {
+			shardIndex, _ = strconv.Atoi(parts[0])
+			shardCount, _ = strconv.Atoi(parts[1])
+		}
+		if len(parts) != 2 || shardCount <= 0 || shardIndex <= 0 || shardIndex > shardCount {
+			return nil, fmt.Errorf("invalid -shard spec %q, want i/n with 1<=i<=n", shardSpec)
+		}
+		seen := 0
+		for i := range cases {
+			if !include[i] {
+				continue
+			}
+			seen++
+			if (seen-1)%shardCount != shardIndex-1 {
+				include[i] = false
+			}
+		}
+	}
+
+	return include, nil
+}
+
+// TestResult is one entry of the driver-mode JSON report.
+type TestResult struct {
+	Name   string                 `json:"name"`
+	Result string                 `json:"result"` // PASS|FAIL|SKIP
+	Reason string                 `json:"reason,omitempty"`
+	State  map[string]interface{} `json:"state,omitempty"`
+}
+
+type resultsDoc struct {
+	Tests []TestResult `json:"tests"`
+}
+
+// runDriverMode runs the selected subset of testCases against a client
+// binary, one ephemeral-port server per case, and writes a JSON report.
+func runDriverMode(driverCmd string, include []bool, jsonOutputPath string, sessionTickets int) error {
+	caDir, err := os.MkdirTemp("", "zig-tls-test-ca-")
+	if err != nil {
+		return fmt.Errorf("create temp CA dir: %w", err)
+	}
+	defer os.RemoveAll(caDir)
+
+	caPaths := make(map[string]string, len(certBundles))
+	for keyType, bundle := range certBundles {
+		path := filepath.Join(caDir, fmt.Sprintf("ca_%s.pem", keyType))
+		if err := os.WriteFile(path, bundle.CACertPEM, 0644); err != nil {
+			return fmt.Errorf("write CA for %s: %w", keyType, err)
+		}
+		caPaths[keyType] = path
+	}
+
+	results := make([]TestResult, 0, len(testCases))
+	for i, tc := range testCases {
+		if !include[i] {
+			results = append(results, TestResult{Name: tc.Name, Result: "SKIP", Reason: "excluded by -run/-shard"})
+			continue
+		}
+		if tc.IsDTLS {
+			results = append(results, TestResult{Name: tc.Name, Result: "SKIP", Reason: "driver mode does not drive DTLS cases; run with --dtls instead"})
+			continue
+		}
+		bundle := certBundles[tc.KeyType]
+		if bundle == nil {
+			results = append(results, TestResult{Name: tc.Name, Result: "FAIL", Reason: fmt.Sprintf("no cert bundle for key type %q", tc.KeyType)})
+			continue
+		}
+		log.Printf("[driver] running %s", tc.Name)
+		results = append(results, runDriverCase(tc, bundle, caPaths[tc.KeyType], driverCmd, sessionTickets))
+	}
+
+	data, err := json.MarshalIndent(resultsDoc{Tests: results}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal results: %w", err)
+	}
+
+	if jsonOutputPath != "" {
+		if err := os.WriteFile(jsonOutputPath, data, 0644); err != nil {
+			return fmt.Errorf("write %s: %w", jsonOutputPath, err)
+		}
+		log.Printf("[driver] wrote %d results to %s", len(results), jsonOutputPath)
+	} else {
+		os.Stdout.Write(data)
+		os.Stdout.Write([]byte("\n"))
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Result == "FAIL" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d selected tests failed", failed, len(results))
+	}
+	return nil
+}
+
+// runDriverCase listens on an ephemeral port for a single TestCase, invokes
+// the client binary against it, and reconciles the client's exit status
+// with what the server side observed.
+func runDriverCase(tc TestCase, bundle *CertBundle, caPath, driverCmd string, sessionTickets int) TestResult {
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return TestResult{Name: tc.Name, Result: "FAIL", Reason: fmt.Sprintf("listen: %v", err)}
+	}
+
+	var innerListener net.Listener = rawListener
+	if tc.Fault != nil {
+		innerListener = &faultListener{Listener: rawListener, profile: tc.Fault}
+	}
+	listener := tls.NewListener(innerListener, buildTLSConfig(tc, bundle))
+	defer listener.Close()
+
+	port := rawListener.Addr().(*net.TCPAddr).Port
+	alpn := strings.Join(tc.ALPN, ",")
+
+	resultCh := make(chan connResult, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			resultCh <- connResult{Reason: fmt.Sprintf("accept: %v", err)}
+			return
+		}
+		start := time.Now()
+		r := handleConnection(conn, tc, sessionTickets)
+		r.Elapsed = time.Since(start)
+		resultCh <- r
+	}()
+
+	cmd := exec.Command(driverCmd,
+		"--host", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--ca", caPath,
+		"--test", tc.Name,
+		"--alpn", alpn,
+	)
+	cmd.Env = append(os.Environ(),
+		"ZIG_TLS_TEST_HOST=127.0.0.1",
+		fmt.Sprintf("ZIG_TLS_TEST_PORT=%d", port),
+		fmt.Sprintf("ZIG_TLS_TEST_CA=%s", caPath),
+		fmt.Sprintf("ZIG_TLS_TEST_ALPN=%s", alpn),
+		fmt.Sprintf("ZIG_TLS_TEST_NAME=%s", tc.Name),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	clientErr := cmd.Run()
+
+	var conn connResult
+	select {
+	case conn = <-resultCh:
+	case <-time.After(30 * time.Second):
+		conn = connResult{Reason: "timed out waiting for the server-side connection to finish"}
+	}
+
+	stderrExcerpt := stderr.String()
+	if len(stderrExcerpt) > 2048 {
+		stderrExcerpt = stderrExcerpt[:2048] + "...(truncated)"
+	}
+
+	state := map[string]interface{}{
+		"handshake_ok":   conn.HandshakeOK,
+		"version":        versionName(conn.Version),
+		"cipher":         tls.CipherSuiteName(conn.Cipher),
+		"alpn":           conn.ALPN,
+		"sni":            conn.SNI,
+		"bytes_echoed":   conn.BytesEchoed,
+		"elapsed_ms":     conn.Elapsed.Milliseconds(),
+		"stderr_excerpt": stderrExcerpt,
+	}
+
+	result := "PASS"
+	var reasons []string
+	if clientErr != nil {
+		result = "FAIL"
+		reasons = append(reasons, fmt.Sprintf("client exited with error: %v", clientErr))
+	}
+	// For fault-injection cases a server-side handshake failure is the
+	// expected outcome, so only the client's exit code decides PASS/FAIL;
+	// for every other case the server must also see a clean handshake.
+	if !conn.HandshakeOK {
+		if conn.Reason != "" {
+			reasons = append(reasons, conn.Reason)
+		}
+		if tc.Fault == nil {
+			result = "FAIL"
+		}
+	}
+
+	return TestResult{Name: tc.Name, Result: result, Reason: strings.Join(reasons, "; "), State: state}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/haivivi/giztoy/go/pkg/mqtt0"
+)
+
+// aclAuthenticator implements mqtt0.Authenticator against a simple ACL file,
+// in place of mqtt0.AllowAll{}.
+//
+// NOTE: mqtt0.Authenticator's exact method set is defined in the external
+// giztoy/go/pkg/mqtt0 package, which this tree has no vendored copy of (see
+// the main package doc comment). This assumes the minimal
+// Authenticate(clientID, username, password string) bool method that
+// mqtt0.AllowAll{} is known to satisfy, since that's the only Authenticator
+// call mqtt_server/mqtt_client exercise anywhere in this repo. Per-topic
+// publish/subscribe ACL is therefore enforced in main's Handler wrapper
+// instead of through the Authenticator, since there's no evidence of a
+// broker-side per-topic hook to implement against.
+//
+// ACL file format, one rule per line, '#'-prefixed lines and blank lines
+// ignored:
+//
+//	user <username> <password>
+//	topic <username-or-*> <allow|deny> <topic-pattern>
+//
+// Topic patterns support the MQTT + (single level) and # (trailing
+// multi-level) wildcards. Rules are evaluated in order; the last matching
+// "topic" rule for a username wins, and a topic with no matching rule is
+// denied.
+type aclAuthenticator struct {
+	creds map[string]string
+	rules []aclRule
+
+	mu        sync.Mutex
+	usernames map[string]string // clientID -> username, recorded on Authenticate
+}
+
+type aclRule struct {
+	user  string
+	allow bool
+	topic string
+}
+
+func buildAuthenticator(path string) (mqtt0.Authenticator, error) {
+	if path == "" {
+		return mqtt0.AllowAll{}, nil
+	}
+	return loadACL(path)
+}
+
+func loadACL(path string) (*aclAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &aclAuthenticator{creds: map[string]string{}, usernames: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "user":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%s:%d: want \"user <username> <password>\"", path, lineNo)
+			}
+			a.creds[fields[1]] = fields[2]
+		case "topic":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("%s:%d: want \"topic <username-or-*> <allow|deny> <pattern>\"", path, lineNo)
+			}
+			allow, err := parseAllowDeny(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			a.rules = append(a.rules, aclRule{user: fields[1], allow: allow, topic: fields[3]})
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule %q", path, lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func parseAllowDeny(s string) (bool, error) {
+	switch s {
+	case "allow":
+		return true, nil
+	case "deny":
+		return false, nil
+	default:
+		return false, fmt.Errorf("want \"allow\" or \"deny\", got %q", s)
+	}
+}
+
+// Authenticate implements mqtt0.Authenticator. It also records the
+// clientID->username mapping, since Handler only gets a clientID and this is
+// the only hook that ever sees both together.
+func (a *aclAuthenticator) Authenticate(clientID, username, password string) bool {
+	want, ok := a.creds[username]
+	if !ok || want != password {
+		return false
+	}
+	a.mu.Lock()
+	a.usernames[clientID] = username
+	a.mu.Unlock()
+	return true
+}
+
+// usernameFor returns the username a clientID authenticated with, or "" if
+// unknown (e.g. AllowAll is in effect, or Authenticate hasn't run for it).
+func (a *aclAuthenticator) usernameFor(clientID string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.usernames[clientID]
+}
+
+// allowedTopic reports whether username may publish/subscribe to topic,
+// applied by main's Handler wrapper on each inbound publish since there's no
+// Authenticator-level hook for it in the surface this tree can see.
+func (a *aclAuthenticator) allowedTopic(username, topic string) bool {
+	allowed := false
+	for _, r := range a.rules {
+		if r.user != "*" && r.user != username {
+			continue
+		}
+		if topicMatches(r.topic, topic) {
+			allowed = r.allow
+		}
+	}
+	return allowed
+}
+
+// topicMatches reports whether topic matches pattern, supporting the MQTT +
+// (single level) and # (trailing, multi-level) wildcards.
+func topicMatches(pattern, topic string) bool {
+	pLevels := strings.Split(pattern, "/")
+	tLevels := strings.Split(topic, "/")
+	for i, p := range pLevels {
+		if p == "#" {
+			return true
+		}
+		if i >= len(tLevels) {
+			return false
+		}
+		if p != "+" && p != tLevels[i] {
+			return false
+		}
+	}
+	return len(pLevels) == len(tLevels)
+}
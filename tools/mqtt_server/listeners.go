@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/haivivi/giztoy/go/pkg/mqtt0"
+)
+
+// listenerConfig holds the flag-derived settings for every listener this
+// binary may open.
+type listenerConfig struct {
+	addr     string
+	tlsAddr  string
+	tlsCert  string
+	tlsKey   string
+	clientCA string
+	wsAddr   string
+}
+
+// startListeners opens every configured listener and starts broker.Serve
+// against each in its own goroutine, so a single Broker can accept plain
+// TCP, TLS, and WebSocket clients concurrently. It returns the opened
+// listeners so the caller can close them on shutdown.
+func startListeners(broker *mqtt0.Broker, cfg listenerConfig) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	ln, err := mqtt0.Listen("tcp", cfg.addr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tcp listen on %s: %w", cfg.addr, err)
+	}
+	listeners = append(listeners, ln)
+	fmt.Printf("MQTT broker listening on %s (tcp)\n", cfg.addr)
+	go serveListener(broker, ln, "tcp")
+
+	if cfg.tlsAddr != "" {
+		tlsConfig, err := buildTLSConfig(cfg.tlsCert, cfg.tlsKey, cfg.clientCA)
+		if err != nil {
+			closeAll(listeners)
+			return nil, fmt.Errorf("tls config: %w", err)
+		}
+		tln, err := mqtt0.Listen("tcp", cfg.tlsAddr, tlsConfig)
+		if err != nil {
+			closeAll(listeners)
+			return nil, fmt.Errorf("tls listen on %s: %w", cfg.tlsAddr, err)
+		}
+		listeners = append(listeners, tln)
+		fmt.Printf("MQTT broker listening on %s (tls)\n", cfg.tlsAddr)
+		go serveListener(broker, tln, "tls")
+	}
+
+	if cfg.wsAddr != "" {
+		wln := newWSListener(cfg.wsAddr)
+		listeners = append(listeners, wln)
+		fmt.Printf("MQTT broker listening on %s (websocket, path /mqtt)\n", cfg.wsAddr)
+		go wln.serveHTTP()
+		go serveListener(broker, wln, "websocket")
+	}
+
+	return listeners, nil
+}
+
+func serveListener(broker *mqtt0.Broker, ln net.Listener, name string) {
+	if err := broker.Serve(ln); err != nil {
+		// Expected on shutdown once the listener is closed out from under
+		// Serve's Accept loop; only surface anything else.
+		if !errors.Is(err, net.ErrClosed) {
+			fmt.Fprintf(os.Stderr, "[%s] broker.Serve error: %v\n", name, err)
+		}
+	}
+}
+
+func closeAll(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// buildTLSConfig assembles the TLS server config for -tls-addr. When
+// clientCA is set it requires and verifies a client certificate, but mapping
+// the certificate's CN to an MQTT username would need the broker to expose
+// the peer's verified chain up through whatever calls into mqtt0.Authenticator
+// — that hook isn't part of the Authenticator surface this tree can see, so
+// -client-ca here only gates the TLS handshake, not CN-as-username.
+func buildTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("-tls-cert and -tls-key are required when -tls-addr is set")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load cert/key: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// wsListener bridges gorilla/websocket connections into a net.Listener, so
+// mqtt0.Broker.Serve can treat MQTT-over-WebSocket clients the same as any
+// other accepted connection without WebSocket support inside mqtt0 itself.
+type wsListener struct {
+	addr     string
+	upgrader websocket.Upgrader
+	server   *http.Server
+	connCh   chan net.Conn
+	closeCh  chan struct{}
+	closeOne sync.Once
+}
+
+func newWSListener(addr string) *wsListener {
+	l := &wsListener{
+		addr: addr,
+		upgrader: websocket.Upgrader{
+			Subprotocols:    []string{"mqtt"},
+			CheckOrigin:     func(*http.Request) bool { return true },
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mqtt", l.handleUpgrade)
+	l.server = &http.Server{Addr: addr, Handler: mux}
+	return l
+}
+
+func (l *wsListener) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	conn, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case l.connCh <- &wsConn{Conn: conn}:
+	case <-l.closeCh:
+		conn.Close()
+	}
+}
+
+func (l *wsListener) serveHTTP() {
+	if err := l.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "[websocket] http.Serve error: %v\n", err)
+	}
+}
+
+// Accept implements net.Listener.
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.connCh:
+		return c, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *wsListener) Close() error {
+	l.closeOne.Do(func() { close(l.closeCh) })
+	return l.server.Close()
+}
+
+// Addr implements net.Listener.
+func (l *wsListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// wsConn adapts a *websocket.Conn's message-oriented API to the net.Conn
+// stream interface mqtt0 expects from a net.Listener, buffering leftover
+// bytes from a WebSocket binary frame across short Read calls.
+type wsConn struct {
+	*websocket.Conn
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		_, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = data
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
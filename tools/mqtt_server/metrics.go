@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// startMetricsServer serves /metrics (Prometheus text exposition),
+// /healthz, and /debug/clients on addr. It returns immediately; Serve
+// errors other than a clean shutdown are logged, matching serveListener's
+// treatment of broker.Serve in listeners.go.
+func startMetricsServer(addr string, stats *brokerStats) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(w, stats.snapshot())
+	})
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/debug/clients", func(w http.ResponseWriter, r *http.Request) {
+		handleDebugClients(w, stats.snapshot())
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("[metrics] http.Serve error: %v\n", err)
+		}
+	}()
+	fmt.Printf("MQTT broker metrics listening on %s (/metrics, /healthz, /debug/clients)\n", addr)
+	return server
+}
+
+// handleMetrics renders snap in Prometheus text exposition format. The
+// mqtt_messages_sent_total, mqtt_bytes_sent_total, and
+// mqtt_subscriptions_count gauges are always 0; see brokerStats' NOTE on
+// scope for why.
+func handleMetrics(w http.ResponseWriter, snap snapshot) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP mqtt_uptime_seconds Seconds since the broker started.")
+	fmt.Fprintln(w, "# TYPE mqtt_uptime_seconds gauge")
+	fmt.Fprintf(w, "mqtt_uptime_seconds %f\n", snap.uptime.Seconds())
+
+	fmt.Fprintln(w, "# HELP mqtt_clients_connected Currently connected clients.")
+	fmt.Fprintln(w, "# TYPE mqtt_clients_connected gauge")
+	fmt.Fprintf(w, "mqtt_clients_connected %d\n", snap.clientsConnected)
+
+	fmt.Fprintln(w, "# HELP mqtt_messages_received_total PUBLISH packets received from clients.")
+	fmt.Fprintln(w, "# TYPE mqtt_messages_received_total counter")
+	fmt.Fprintf(w, "mqtt_messages_received_total %d\n", snap.messagesReceived)
+
+	fmt.Fprintln(w, "# HELP mqtt_messages_sent_total PUBLISH packets forwarded to subscribers. Always 0; see retained.go-era NOTE on scope in stats.go.")
+	fmt.Fprintln(w, "# TYPE mqtt_messages_sent_total counter")
+	fmt.Fprintln(w, "mqtt_messages_sent_total 0")
+
+	fmt.Fprintln(w, "# HELP mqtt_bytes_received_total Payload bytes received from clients.")
+	fmt.Fprintln(w, "# TYPE mqtt_bytes_received_total counter")
+	fmt.Fprintf(w, "mqtt_bytes_received_total %d\n", snap.bytesReceived)
+
+	fmt.Fprintln(w, "# HELP mqtt_bytes_sent_total Payload bytes forwarded to subscribers. Always 0; see NOTE on scope in stats.go.")
+	fmt.Fprintln(w, "# TYPE mqtt_bytes_sent_total counter")
+	fmt.Fprintln(w, "mqtt_bytes_sent_total 0")
+
+	fmt.Fprintln(w, "# HELP mqtt_subscriptions_count Active subscriptions across all clients. Always 0; see NOTE on scope in stats.go.")
+	fmt.Fprintln(w, "# TYPE mqtt_subscriptions_count gauge")
+	fmt.Fprintln(w, "mqtt_subscriptions_count 0")
+
+	fmt.Fprintln(w, "# HELP mqtt_retained_count Distinct topics with a retained message outstanding.")
+	fmt.Fprintln(w, "# TYPE mqtt_retained_count gauge")
+	fmt.Fprintf(w, "mqtt_retained_count %d\n", snap.retainedCount)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, `{"status":"ok"}`)
+}
+
+// debugClient is handleDebugClients' JSON shape for one connected client.
+// protocolVersion, keepAliveSeconds, and subscriptions aren't populated;
+// see clientInfo's doc comment for why this tree can't see them.
+type debugClient struct {
+	ClientID         string   `json:"clientId"`
+	Username         string   `json:"username,omitempty"`
+	ConnectedSeconds int64    `json:"connectedSeconds"`
+	ProtocolVersion  string   `json:"protocolVersion,omitempty"`
+	KeepAliveSeconds int      `json:"keepAliveSeconds,omitempty"`
+	Subscriptions    []string `json:"subscriptions,omitempty"`
+}
+
+func handleDebugClients(w http.ResponseWriter, snap snapshot) {
+	now := time.Now()
+	out := make([]debugClient, 0, len(snap.clients))
+	for _, c := range snap.clients {
+		out = append(out, debugClient{
+			ClientID:         c.clientID,
+			Username:         c.username,
+			ConnectedSeconds: int64(now.Sub(c.connectedAt).Seconds()),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+}
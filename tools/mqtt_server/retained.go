@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/haivivi/giztoy/go/pkg/mqtt0"
+)
+
+// retainedMirror mirrors retained PUBLISH payloads to -state-dir so they
+// survive a broker restart. It's a mirror, not a real retained-message
+// store: mqtt0.Broker keeps its own in-memory retained set, so on startup
+// this package republishes what it persisted back through broker.Publish
+// (the same server-originated publish method sys.go's $SYS publisher
+// assumes; see its NOTE on scope) to reseed that in-memory set, rather than
+// reaching into the broker's internals directly.
+type retainedMirror struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string][]byte // topic -> last-seen retained payload
+}
+
+// newRetainedMirror opens (creating if needed) dir and loads any payloads
+// persisted by a previous run.
+func newRetainedMirror(dir string) (*retainedMirror, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	m := &retainedMirror{dir: dir, entries: map[string][]byte{}}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read state dir: %w", err)
+	}
+	for _, ent := range ents {
+		if ent.IsDir() || filepath.Ext(ent.Name()) != ".topic" {
+			continue
+		}
+		topic, err := os.ReadFile(filepath.Join(dir, ent.Name()))
+		if err != nil {
+			continue
+		}
+		payload, err := os.ReadFile(filepath.Join(dir, payloadFileName(ent.Name())))
+		if err != nil {
+			continue
+		}
+		m.entries[string(topic)] = payload
+	}
+	return m, nil
+}
+
+// observe records topic's latest retained payload, both in memory and on
+// disk, so a later run's newRetainedMirror picks it back up.
+func (m *retainedMirror) observe(topic string, payload []byte) {
+	m.mu.Lock()
+	cp := append([]byte(nil), payload...)
+	m.entries[topic] = cp
+	m.mu.Unlock()
+
+	base := topicFileBase(topic)
+	if err := os.WriteFile(filepath.Join(m.dir, base+".topic"), []byte(topic), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "[retained] failed to persist topic %q: %v\n", topic, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, base+".payload"), cp, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "[retained] failed to persist payload for %q: %v\n", topic, err)
+	}
+}
+
+// replayInto republishes every retained payload the mirror loaded from a
+// previous run back through broker, retained, so it reseeds mqtt0.Broker's
+// in-memory retained set before any listener starts accepting clients. It
+// logs each replay publish that failed, then how many of the loaded
+// entries were replayed.
+func (m *retainedMirror) replayInto(broker *mqtt0.Broker) {
+	m.mu.Lock()
+	entries := make(map[string][]byte, len(m.entries))
+	for topic, payload := range m.entries {
+		entries[topic] = payload
+	}
+	m.mu.Unlock()
+
+	if len(entries) == 0 {
+		fmt.Printf("[retained] %s: no persisted retained messages found\n", m.dir)
+		return
+	}
+
+	replayed := 0
+	for topic, payload := range entries {
+		if err := broker.Publish(topic, payload, true); err != nil {
+			fmt.Fprintf(os.Stderr, "[retained] failed to replay %q: %v\n", topic, err)
+			continue
+		}
+		replayed++
+	}
+	fmt.Printf("[retained] %s: replayed %d/%d persisted retained message(s) into the broker\n", m.dir, replayed, len(entries))
+}
+
+// flush is a no-op beyond reporting, since observe already writes through
+// on every retained message; it exists so shutdown has a single place to
+// report the final count, matching the startup summary.
+func (m *retainedMirror) flush() {
+	m.mu.Lock()
+	n := len(m.entries)
+	m.mu.Unlock()
+	fmt.Printf("[retained] %s: %d retained message(s) on disk at shutdown\n", m.dir, n)
+}
+
+// topicFileBase derives a filesystem-safe, collision-resistant base name
+// for topic's two on-disk files from its SHA-256, since MQTT topics can
+// contain '/' and arbitrary UTF-8.
+func topicFileBase(topic string) string {
+	sum := sha256.Sum256([]byte(topic))
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadFileName returns the sibling ".payload" file name for a ".topic"
+// file name, as produced by topicFileBase.
+func payloadFileName(topicFileName string) string {
+	return topicFileName[:len(topicFileName)-len(".topic")] + ".payload"
+}
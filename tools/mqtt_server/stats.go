@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// brokerStats accumulates the counters -metrics-addr and $SYS publishing
+// both read from, fed by main's OnConnect/OnDisconnect/Handler hooks.
+//
+// NOTE on scope: the request behind this file asked for messages/sent,
+// bytes/sent, and subscriptions/count, which would need OnPublishAck,
+// OnBytes, and a subscription-counting hook on mqtt0.Broker itself — none
+// of which are part of the Authenticator/Handler/OnConnect/OnDisconnect
+// surface this tree has ever assumed (see tools/mqtt_server/main.go's
+// package doc comment). Those three are not tracked here at all: /metrics
+// and the $SYS publisher report them as a constant 0, rather than guessing
+// at a broker change this tree can't make.
+// messagesReceived/bytesReceived/retainedCount are all derived from what
+// Handler already sees on each inbound publish.
+type brokerStats struct {
+	startedAt time.Time
+
+	clientsConnected atomic.Int64
+	messagesReceived atomic.Int64
+	bytesReceived    atomic.Int64
+
+	mu       sync.Mutex
+	retained map[string]bool // topics with a non-empty retained payload outstanding
+	clients  map[string]*clientInfo
+}
+
+// clientInfo is what /debug/clients can report about a connected client
+// from the OnConnect/OnDisconnect/Authenticate hooks alone.
+//
+// mqtt0 doesn't hand OnConnect a protocol version, keep-alive, or
+// subscription list in the surface this tree can see, so those fields
+// aren't tracked here; /debug/clients reports connectedAt and username
+// (when an ACL authenticator is in effect) instead of guessing at them.
+type clientInfo struct {
+	clientID    string
+	username    string
+	connectedAt time.Time
+}
+
+func newBrokerStats() *brokerStats {
+	return &brokerStats{
+		startedAt: time.Now(),
+		retained:  map[string]bool{},
+		clients:   map[string]*clientInfo{},
+	}
+}
+
+func (s *brokerStats) recordConnect(clientID, username string) {
+	s.clientsConnected.Add(1)
+	s.mu.Lock()
+	s.clients[clientID] = &clientInfo{clientID: clientID, username: username, connectedAt: time.Now()}
+	s.mu.Unlock()
+}
+
+func (s *brokerStats) recordDisconnect(clientID string) {
+	s.clientsConnected.Add(-1)
+	s.mu.Lock()
+	delete(s.clients, clientID)
+	s.mu.Unlock()
+}
+
+// recordPublish folds an inbound PUBLISH into the counters. A retained
+// message with an empty payload clears that topic's retention, matching
+// normal MQTT retained-message semantics.
+func (s *brokerStats) recordPublish(topic string, payload []byte, retain bool) {
+	s.messagesReceived.Add(1)
+	s.bytesReceived.Add(int64(len(payload)))
+	if !retain {
+		return
+	}
+	s.mu.Lock()
+	if len(payload) == 0 {
+		delete(s.retained, topic)
+	} else {
+		s.retained[topic] = true
+	}
+	s.mu.Unlock()
+}
+
+// snapshot is an immutable copy of the counters at one instant, for
+// /metrics, $SYS publishing, and /debug/clients to render from without
+// holding s.mu across formatting.
+type snapshot struct {
+	uptime           time.Duration
+	clientsConnected int64
+	messagesReceived int64
+	bytesReceived    int64
+	retainedCount    int
+	clients          []clientInfo
+}
+
+func (s *brokerStats) snapshot() snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]clientInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, *c)
+	}
+	return snapshot{
+		uptime:           time.Since(s.startedAt),
+		clientsConnected: s.clientsConnected.Load(),
+		messagesReceived: s.messagesReceived.Load(),
+		bytesReceived:    s.bytesReceived.Load(),
+		retainedCount:    len(s.retained),
+		clients:          clients,
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/haivivi/giztoy/go/pkg/mqtt0"
+)
+
+// runSysPublisher publishes the standard $SYS/broker/... topics from stats
+// on every tick, retained, until stop is closed.
+//
+// NOTE: this assumes *mqtt0.Broker has a Publish(topic string, payload
+// []byte, retain bool) error method symmetric to the Message fields
+// Handler already receives, since that's the narrowest server-originated
+// publish API a broker could expose and nothing in this tree contradicts
+// it; if mqtt0.Broker has no such method, this is the one place that would
+// need to change once a real checkout is available.
+func runSysPublisher(broker *mqtt0.Broker, stats *brokerStats, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			publishSysTopics(broker, stats.snapshot())
+		}
+	}
+}
+
+func publishSysTopics(broker *mqtt0.Broker, snap snapshot) {
+	publishSys(broker, "$SYS/broker/uptime", fmt.Sprintf("%d", int64(snap.uptime.Seconds())))
+	publishSys(broker, "$SYS/broker/clients/connected", fmt.Sprintf("%d", snap.clientsConnected))
+	publishSys(broker, "$SYS/broker/messages/received", fmt.Sprintf("%d", snap.messagesReceived))
+	publishSys(broker, "$SYS/broker/messages/sent", "0") // see brokerStats' NOTE on scope
+	publishSys(broker, "$SYS/broker/bytes/received", fmt.Sprintf("%d", snap.bytesReceived))
+	publishSys(broker, "$SYS/broker/bytes/sent", "0")          // see brokerStats' NOTE on scope
+	publishSys(broker, "$SYS/broker/subscriptions/count", "0") // see brokerStats' NOTE on scope
+	publishSys(broker, "$SYS/broker/retained/count", fmt.Sprintf("%d", snap.retainedCount))
+}
+
+func publishSys(broker *mqtt0.Broker, topic, payload string) {
+	if err := broker.Publish(topic, []byte(payload), true); err != nil {
+		fmt.Printf("[sys] publish %s failed: %v\n", topic, err)
+	}
+}
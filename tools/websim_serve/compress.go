@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// precompressed variants are tried in this order (best compression first)
+// for any request whose Accept-Encoding allows it.
+var precompressedExts = []struct {
+	ext      string
+	encoding string
+}{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// servePrecompressed serves path+".br" or path+".gz" in place of path when
+// the client's Accept-Encoding allows it and the sibling file exists,
+// setting Content-Encoding so the browser decodes it transparently. It
+// reports whether it served the request; on false the caller should fall
+// through to its normal file serving.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, siteDir, urlPath string) bool {
+	accept := r.Header.Get("Accept-Encoding")
+	if accept == "" {
+		return false
+	}
+
+	cleanPath := filepath.Clean(filepath.Join(siteDir, filepath.FromSlash(urlPath)))
+	for _, variant := range precompressedExts {
+		if !acceptsEncoding(accept, variant.encoding) {
+			continue
+		}
+		variantPath := cleanPath + variant.ext
+		info, err := os.Stat(variantPath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		f, err := os.Open(variantPath)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		if ct := contentTypeFor(urlPath); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Content-Encoding", variant.encoding)
+		w.Header().Set("Vary", "Accept-Encoding")
+		http.ServeContent(w, r, urlPath, info.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether encoding appears in an Accept-Encoding
+// header value, ignoring any q= weighting (a 0-weighted "q=0" entry is
+// treated as accepted too; precompressed serving is an optimization, not
+// something worth a full RFC 7231 parser for).
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(name, encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentTypeFor returns the Content-Type a precompressed file's original
+// (uncompressed) extension implies, so serving foo.wasm.br still reports
+// application/wasm rather than whatever .br sniffs to.
+func contentTypeFor(urlPath string) string {
+	switch {
+	case strings.HasSuffix(urlPath, ".wasm"):
+		return "application/wasm"
+	case strings.HasSuffix(urlPath, ".js"):
+		return "text/javascript; charset=utf-8"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// headerRule is one "<glob> <Header-Name>: <value>" line from -headers-file.
+type headerRule struct {
+	glob   string
+	name   string
+	value  string
+	source string // "<path>:<line>", for error messages
+}
+
+// loadHeaderRules parses -headers-file. Blank lines and '#'-prefixed lines
+// are ignored. Each remaining line is "<glob> <Header-Name>: <value>",
+// e.g.:
+//
+//	*.wasm Cache-Control: no-cache
+//	assets/* Access-Control-Allow-Origin: *
+func loadHeaderRules(path string) ([]headerRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []headerRule
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		glob, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want \"<glob> <Header-Name>: <value>\"", path, lineNo)
+		}
+		name, value, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want \"<glob> <Header-Name>: <value>\"", path, lineNo)
+		}
+		rules = append(rules, headerRule{
+			glob:   glob,
+			name:   strings.TrimSpace(name),
+			value:  strings.TrimSpace(value),
+			source: fmt.Sprintf("%s:%d", path, lineNo),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// applyHeaderRules sets every rule whose glob matches urlPath (relative to
+// siteDir, e.g. "assets/demo.wasm"). Rules are applied in file order, so a
+// later matching rule for the same header overrides an earlier one.
+func applyHeaderRules(w http.ResponseWriter, rules []headerRule, urlPath string) {
+	clean := strings.TrimPrefix(urlPath, "/")
+	for _, r := range rules {
+		matched, err := filepath.Match(r.glob, clean)
+		if err != nil || !matched {
+			continue
+		}
+		w.Header().Set(r.name, r.value)
+	}
+}
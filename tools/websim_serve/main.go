@@ -3,10 +3,22 @@
 // Serves a directory of static files (HTML/JS/CSS/WASM) and opens the browser.
 // Used by the websim_app Bazel rule's :serve target.
 //
-// Usage: websim_serve <site_dir> [--port=8080]
+// Usage: websim_serve <site_dir> [--port=8080] [--coop] [--coep] [--corp=same-origin]
+//
+// Features:
+//   - Transparently serves foo.wasm.br/foo.wasm.gz in place of foo.wasm
+//     when the client's Accept-Encoding allows it (see compress.go)
+//   - -coop/-coep (on by default) set the Cross-Origin-Opener-Policy and
+//     Cross-Origin-Embedder-Policy headers SharedArrayBuffer needs;
+//     -corp sets Cross-Origin-Resource-Policy for sibling resources
+//   - -headers-file maps glob patterns to extra response headers for
+//     per-asset CORS/CSP (see headers.go)
+//   - Polls siteDir for changes and pushes a reload over a /__reload
+//     WebSocket to any open .html page (see reload.go)
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
@@ -14,12 +26,21 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 )
 
 func main() {
 	port := flag.Int("port", 0, "port to listen on (0 = auto)")
 	noOpen := flag.Bool("no-open", false, "don't open browser")
+	coop := flag.Bool("coop", true, "set Cross-Origin-Opener-Policy: same-origin")
+	coep := flag.Bool("coep", true, "set Cross-Origin-Embedder-Policy: require-corp")
+	corp := flag.String("corp", "same-origin", "Cross-Origin-Resource-Policy value for served resources (empty disables it)")
+	headersFile := flag.String("headers-file", "", "file of \"<glob> <Header-Name>: <value>\" rules for custom per-asset headers (empty disables)")
+	liveReload := flag.Bool("live-reload", true, "watch site_dir and reload open pages when files change")
+	watchInterval := flag.Duration("watch-interval", 500*time.Millisecond, "how often to poll site_dir for changes")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -34,15 +55,52 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Set correct MIME type for .wasm files
+	var headerRules []headerRule
+	if *headersFile != "" {
+		rules, err := loadHeaderRules(*headersFile)
+		if err != nil {
+			log.Fatalf("failed to load -headers-file: %v", err)
+		}
+		headerRules = rules
+	}
+
+	var hub *reloadHub
+	if *liveReload {
+		hub = newReloadHub()
+		go watchSiteDir(siteDir, *watchInterval, hub)
+	}
+
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir(siteDir))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if len(r.URL.Path) > 5 && r.URL.Path[len(r.URL.Path)-5:] == ".wasm" {
+		if *coop {
+			w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+		}
+		if *coep {
+			w.Header().Set("Cross-Origin-Embedder-Policy", "require-corp")
+		}
+		if *corp != "" {
+			w.Header().Set("Cross-Origin-Resource-Policy", *corp)
+		}
+		if headerRules != nil {
+			applyHeaderRules(w, headerRules, r.URL.Path)
+		}
+
+		if strings.HasSuffix(r.URL.Path, ".wasm") {
 			w.Header().Set("Content-Type", "application/wasm")
 		}
+		if servePrecompressed(w, r, siteDir, r.URL.Path) {
+			return
+		}
+		if hub != nil && strings.HasSuffix(r.URL.Path, ".html") {
+			serveWithReloadScript(w, r, fs, siteDir)
+			return
+		}
 		fs.ServeHTTP(w, r)
 	})
+	if hub != nil {
+		mux.HandleFunc("/__reload", hub.handleReload)
+	}
 
 	// Find available port
 	listenAddr := fmt.Sprintf(":%d", *port)
@@ -67,6 +125,28 @@ func main() {
 	}
 }
 
+// serveWithReloadScript serves an .html file with reloadScript spliced in
+// just before </body> (or appended, if the file has none), so it has to
+// read the whole file rather than delegate to fs's streaming FileServer.
+func serveWithReloadScript(w http.ResponseWriter, r *http.Request, fs http.Handler, siteDir string) {
+	path := filepath.Join(siteDir, filepath.FromSlash(strings.TrimPrefix(r.URL.Path, "/")))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fs.ServeHTTP(w, r)
+		return
+	}
+
+	out := data
+	if idx := bytes.LastIndex(data, []byte("</body>")); idx >= 0 {
+		out = append(append(append([]byte{}, data[:idx]...), []byte(reloadScript)...), data[idx:]...)
+	} else {
+		out = append(append([]byte{}, data...), []byte(reloadScript)...)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(out)
+}
+
 func openBrowser(url string) {
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// reloadScript is injected into every served .html response just before
+// </body>. It connects to /__reload and reloads the page on any message;
+// a closed or errored socket is left alone; the next manual refresh picks
+// up a server that has since restarted.
+const reloadScript = `<script>(function(){
+  var ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/__reload");
+  ws.onmessage = function() { location.reload(); };
+})();</script>`
+
+// reloadHub tracks connected /__reload WebSocket clients and broadcasts a
+// reload notification to all of them when watchSiteDir sees a change.
+type reloadHub struct {
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*websocket.Conn]bool
+}
+
+func newReloadHub() *reloadHub {
+	return &reloadHub{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(*http.Request) bool { return true }, // local dev server, not exposed beyond localhost
+		},
+		clients: map[*websocket.Conn]bool{},
+	}
+}
+
+// handleReload upgrades the request and keeps the connection registered
+// until the client disconnects; it never reads application messages, it
+// only waits for a close so it can clean itself up.
+func (h *reloadHub) handleReload(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	h.clients[conn] = true
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, conn)
+		h.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// broadcast sends a reload notification to every connected client,
+// dropping any that error out (handleReload's read loop will clean them
+// up on its own once it notices the close).
+func (h *reloadHub) broadcast() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.clients {
+		conn.WriteMessage(websocket.TextMessage, []byte("reload"))
+	}
+}
+
+// watchSiteDir polls siteDir's file tree every interval and calls
+// h.broadcast whenever any file's mtime (or the set of files itself)
+// changed since the last tick. This is a plain mtime-poll, the same
+// substitute for fsnotify that examples/esp/https_speed_test/server/
+// reload.go uses, since fsnotify isn't vendored into this tree.
+func watchSiteDir(siteDir string, interval time.Duration, h *reloadHub) {
+	last := snapshotTree(siteDir)
+	for range time.Tick(interval) {
+		cur := snapshotTree(siteDir)
+		if !treesEqual(last, cur) {
+			last = cur
+			log.Printf("websim_serve: change detected under %s, reloading browsers", siteDir)
+			h.broadcast()
+		}
+	}
+}
+
+// snapshotTree maps every regular file under siteDir to its mtime. A
+// walk error (e.g. a file removed mid-scan) just drops that entry rather
+// than failing the whole snapshot.
+func snapshotTree(siteDir string) map[string]time.Time {
+	snap := map[string]time.Time{}
+	filepath.Walk(siteDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		snap[path] = info.ModTime()
+		return nil
+	})
+	return snap
+}
+
+func treesEqual(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, mtime := range a {
+		if bmtime, ok := b[path]; !ok || !bmtime.Equal(mtime) {
+			return false
+		}
+	}
+	return true
+}